@@ -0,0 +1,415 @@
+package pefile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"math/big"
+	"time"
+)
+
+var ErrInvalidSignature = errors.New("pefile: invalid authenticode signature")
+
+var (
+	oidSignedData             = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}
+	oidContentType            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 3}
+	oidMessageDigest          = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 4}
+	oidSigningTime            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 5}
+	oidCounterSignature       = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 6}
+	oidTSTInfo                = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 9, 16, 1, 4}
+	oidSpcIndirectDataContent = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 4}
+	oidSpcPeImageData         = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 15}
+	oidSpcSpOpusInfo          = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 12}
+	oidSpcStatementType       = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 11}
+	oidNestedSignature        = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 4, 1}
+	oidRFC3161CounterSign     = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 3, 3, 1}
+)
+
+var hashOIDs = []struct {
+	oid  asn1.ObjectIdentifier
+	hash crypto.Hash
+}{
+	{asn1.ObjectIdentifier{1, 2, 840, 113549, 2, 5}, crypto.MD5},
+	{asn1.ObjectIdentifier{1, 3, 14, 3, 2, 26}, crypto.SHA1},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 1}, crypto.SHA256},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 2}, crypto.SHA384},
+	{asn1.ObjectIdentifier{2, 16, 840, 1, 101, 3, 4, 2, 3}, crypto.SHA512},
+}
+
+// 不支持的算法返回0.
+func hashFromOID(oid asn1.ObjectIdentifier) crypto.Hash {
+	for _, h := range hashOIDs {
+		if h.oid.Equal(oid) {
+			return h.hash
+		}
+	}
+
+	return 0
+}
+
+func oidFromHash(hash crypto.Hash) asn1.ObjectIdentifier {
+	for _, h := range hashOIDs {
+		if h.hash == hash {
+			return h.oid
+		}
+	}
+
+	return nil
+}
+
+// PKCS#7的ASN.1结构, [0]和[1]用RawValue表示以便原样保留.
+type contentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"optional,tag:0"`
+}
+
+type signedData struct {
+	Version          int
+	DigestAlgorithms []pkix.AlgorithmIdentifier `asn1:"set"`
+	ContentInfo      contentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      []signerInfo  `asn1:"set"`
+}
+
+type issuerAndSerial struct {
+	Issuer       asn1.RawValue
+	SerialNumber *big.Int
+}
+
+type signerInfo struct {
+	Version                   int
+	IssuerAndSerialNumber     issuerAndSerial
+	DigestAlgorithm           pkix.AlgorithmIdentifier
+	AuthenticatedAttributes   asn1.RawValue `asn1:"optional,tag:0"`
+	DigestEncryptionAlgorithm pkix.AlgorithmIdentifier
+	EncryptedDigest           []byte
+	UnauthenticatedAttributes asn1.RawValue `asn1:"optional,tag:1"`
+}
+
+type attribute struct {
+	Type   asn1.ObjectIdentifier
+	Values asn1.RawValue //SET OF
+}
+
+type spcAttributeTypeAndOptionalValue struct {
+	Type  asn1.ObjectIdentifier
+	Value asn1.RawValue `asn1:"optional"`
+}
+
+type digestInfo struct {
+	DigestAlgorithm pkix.AlgorithmIdentifier
+	Digest          []byte
+}
+
+type spcIndirectDataContent struct {
+	Data          spcAttributeTypeAndOptionalValue
+	MessageDigest digestInfo
+}
+
+type messageImprint struct {
+	HashAlgorithm pkix.AlgorithmIdentifier
+	HashedMessage []byte
+}
+
+// 只解析TSTInfo开头的字段.
+type tstInfo struct {
+	Version        int
+	Policy         asn1.ObjectIdentifier
+	MessageImprint messageImprint
+	SerialNumber   *big.Int
+	GenTime        time.Time `asn1:"generalized"`
+}
+
+// 上下文相关的[0]标记, 对应EXPLICIT的内容.
+func explicitTag(tag int, der []byte) asn1.RawValue {
+	return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: tag, IsCompound: true, Bytes: der}
+}
+
+type AuthenticodeSignature struct {
+	DigestAlgorithm crypto.Hash //SpcIndirectDataContent中的摘要算法
+	Digest          []byte      //映像的Authenticode哈希
+	Certificates    []*x509.Certificate
+	Signer          *SignerInfo
+	Nested          []*AuthenticodeSignature //1.3.6.1.4.1.311.2.4.1
+	Timestamps      []*Timestamp
+	Raw             []byte //ContentInfo
+	content         []byte //SpcIndirectDataContent, 签名的对象是去掉标记和长度的部分
+}
+
+type SignerInfo struct {
+	Issuer             []byte //DER编码的Name
+	SerialNumber       *big.Int
+	DigestAlgorithm    crypto.Hash
+	SignatureAlgorithm asn1.ObjectIdentifier
+	Signature          []byte
+	Certificate        *x509.Certificate //在证书链中按Issuer和SerialNumber查找, 没有时为nil
+	MessageDigest      []byte            //认证属性中的摘要
+	SigningTime        time.Time
+	attributes         []byte //认证属性, 签名时的标记为SET
+	unauthenticated    []attribute
+}
+
+// 副署(countersignature)的时间戳, RFC3161的时间戳中的Signer签名TSTInfo.
+type Timestamp struct {
+	RFC3161       bool
+	Time          time.Time
+	HashAlgorithm crypto.Hash //RFC3161的MessageImprint的算法
+	HashedMessage []byte      //被副署的签名的哈希
+	Signer        *SignerInfo
+	Certificates  []*x509.Certificate
+	content       []byte //RFC3161: TSTInfo
+}
+
+// 返回全部PKCS#7签名, 嵌套的签名在Nested中.
+func (p *PeFile) Signatures() ([]*AuthenticodeSignature, error) {
+	var ret []*AuthenticodeSignature
+	for _, c := range p.certificates {
+		if c.Type != WIN_CERT_TYPE_PKCS_SIGNED_DATA {
+			continue
+		}
+
+		s, err := ParseAuthenticode(c.Data)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, s)
+	}
+
+	return ret, nil
+}
+
+// 解析PKCS#7 SignedData, data之后的填充被忽略.
+func ParseAuthenticode(data []byte) (*AuthenticodeSignature, error) {
+	sd, raw, err := parseSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sd.ContentInfo.ContentType.Equal(oidSpcIndirectDataContent) || len(sd.SignerInfos) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	ret := &AuthenticodeSignature{Raw: raw, content: sd.ContentInfo.Content.Bytes}
+	var content spcIndirectDataContent
+	if _, err = asn1.Unmarshal(ret.content, &content); err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	ret.DigestAlgorithm = hashFromOID(content.MessageDigest.DigestAlgorithm.Algorithm)
+	ret.Digest = content.MessageDigest.Digest
+	if ret.Certificates, err = parseCertificates(sd.Certificates); err != nil {
+		return nil, err
+	}
+
+	if ret.Signer, err = parseSignerInfo(&sd.SignerInfos[0], ret.Certificates); err != nil {
+		return nil, err
+	}
+
+	for _, a := range ret.Signer.unauthenticated {
+		values, err := attributeValues(a)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, v := range values {
+			switch {
+			case a.Type.Equal(oidNestedSignature):
+				nested, err := ParseAuthenticode(v.FullBytes)
+				if err != nil {
+					return nil, err
+				}
+				ret.Nested = append(ret.Nested, nested)
+			case a.Type.Equal(oidCounterSignature):
+				t, err := parseCounterSignature(v.FullBytes, ret.Certificates)
+				if err != nil {
+					return nil, err
+				}
+				ret.Timestamps = append(ret.Timestamps, t)
+			case a.Type.Equal(oidRFC3161CounterSign):
+				t, err := parseRFC3161(v.FullBytes)
+				if err != nil {
+					return nil, err
+				}
+				ret.Timestamps = append(ret.Timestamps, t)
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// 返回SignedData和ContentInfo的原始数据.
+func parseSignedData(data []byte) (*signedData, []byte, error) {
+	var ci contentInfo
+	rest, err := asn1.Unmarshal(data, &ci)
+	if err != nil || !ci.ContentType.Equal(oidSignedData) {
+		return nil, nil, ErrInvalidSignature
+	}
+
+	sd := &signedData{}
+	if _, err = asn1.Unmarshal(ci.Content.Bytes, sd); err != nil {
+		return nil, nil, ErrInvalidSignature
+	}
+
+	return sd, data[:len(data)-len(rest)], nil
+}
+
+// 只返回X.509证书, 带标记的其它选择(如时间戳服务器附带的属性证书)被忽略.
+func parseCertificates(raw asn1.RawValue) ([]*x509.Certificate, error) {
+	var ret []*x509.Certificate
+	for data := raw.Bytes; len(data) > 0; {
+		var v asn1.RawValue
+		rest, err := asn1.Unmarshal(data, &v)
+		if err != nil {
+			return nil, ErrInvalidSignature
+		}
+		data = rest
+
+		if v.Class != asn1.ClassUniversal || v.Tag != asn1.TagSequence {
+			continue
+		}
+
+		cert, err := x509.ParseCertificate(v.FullBytes)
+		if err != nil {
+			return nil, ErrInvalidSignature
+		}
+		ret = append(ret, cert)
+	}
+
+	return ret, nil
+}
+
+func parseAttributes(data []byte) ([]attribute, error) {
+	var ret []attribute
+	for len(data) > 0 {
+		var a attribute
+		rest, err := asn1.Unmarshal(data, &a)
+		if err != nil {
+			return nil, ErrInvalidSignature
+		}
+
+		ret = append(ret, a)
+		data = rest
+	}
+
+	return ret, nil
+}
+
+func attributeValues(a attribute) ([]asn1.RawValue, error) {
+	var ret []asn1.RawValue
+	for data := a.Values.Bytes; len(data) > 0; {
+		var v asn1.RawValue
+		rest, err := asn1.Unmarshal(data, &v)
+		if err != nil {
+			return nil, ErrInvalidSignature
+		}
+
+		ret = append(ret, v)
+		data = rest
+	}
+
+	return ret, nil
+}
+
+func parseSignerInfo(si *signerInfo, certs []*x509.Certificate) (*SignerInfo, error) {
+	ret := &SignerInfo{
+		Issuer:             si.IssuerAndSerialNumber.Issuer.FullBytes,
+		SerialNumber:       si.IssuerAndSerialNumber.SerialNumber,
+		DigestAlgorithm:    hashFromOID(si.DigestAlgorithm.Algorithm),
+		SignatureAlgorithm: si.DigestEncryptionAlgorithm.Algorithm,
+		Signature:          si.EncryptedDigest,
+	}
+
+	for _, c := range certs {
+		if bytes.Equal(c.RawIssuer, ret.Issuer) && c.SerialNumber.Cmp(ret.SerialNumber) == 0 {
+			ret.Certificate = c
+			break
+		}
+	}
+
+	if len(si.AuthenticatedAttributes.FullBytes) > 0 {
+		//签名的是SET OF Attribute, 不是[0]
+		ret.attributes = append([]byte{}, si.AuthenticatedAttributes.FullBytes...)
+		ret.attributes[0] = 0x31
+
+		attrs, err := parseAttributes(si.AuthenticatedAttributes.Bytes)
+		if err != nil {
+			return nil, err
+		}
+
+		for _, a := range attrs {
+			values, err := attributeValues(a)
+			if err != nil || len(values) == 0 {
+				return nil, ErrInvalidSignature
+			}
+
+			if a.Type.Equal(oidMessageDigest) {
+				asn1.Unmarshal(values[0].FullBytes, &ret.MessageDigest)
+			} else if a.Type.Equal(oidSigningTime) {
+				asn1.Unmarshal(values[0].FullBytes, &ret.SigningTime)
+			}
+		}
+	}
+
+	var err error
+	if ret.unauthenticated, err = parseAttributes(si.UnauthenticatedAttributes.Bytes); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
+
+// PKCS#9的副署是一个SignerInfo, 证书在外层的SignedData中.
+func parseCounterSignature(data []byte, certs []*x509.Certificate) (*Timestamp, error) {
+	var si signerInfo
+	if _, err := asn1.Unmarshal(data, &si); err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	signer, err := parseSignerInfo(&si, certs)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Timestamp{Time: signer.SigningTime, HashAlgorithm: signer.DigestAlgorithm, HashedMessage: signer.MessageDigest,
+		Signer: signer, Certificates: certs}, nil
+}
+
+// RFC3161的时间戳是一个SignedData, 内容是TSTInfo.
+func parseRFC3161(data []byte) (*Timestamp, error) {
+	sd, _, err := parseSignedData(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if !sd.ContentInfo.ContentType.Equal(oidTSTInfo) || len(sd.SignerInfos) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	ret := &Timestamp{RFC3161: true}
+	if _, err = asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &ret.content); err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	var info tstInfo
+	if _, err = asn1.Unmarshal(ret.content, &info); err != nil {
+		return nil, ErrInvalidSignature
+	}
+
+	ret.Time = info.GenTime
+	ret.HashAlgorithm = hashFromOID(info.MessageImprint.HashAlgorithm.Algorithm)
+	ret.HashedMessage = info.MessageImprint.HashedMessage
+	if ret.Certificates, err = parseCertificates(sd.Certificates); err != nil {
+		return nil, err
+	}
+
+	if ret.Signer, err = parseSignerInfo(&sd.SignerInfos[0], ret.Certificates); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
+}
@@ -0,0 +1,153 @@
+package pefile
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+var ErrInvalidCertificate = errors.New("pefile: invalid certificate table")
+
+const (
+	winCertificateHeaderSize = 8
+	maxCertificateTableSize  = 0x10000000
+)
+
+// WIN_CERTIFICATE.wRevision和wCertificateType
+const (
+	WIN_CERT_REVISION_1_0          = 0x0100
+	WIN_CERT_REVISION_2_0          = 0x0200
+	WIN_CERT_TYPE_X509             = 0x0001
+	WIN_CERT_TYPE_PKCS_SIGNED_DATA = 0x0002
+	WIN_CERT_TYPE_TS_STACK_SIGNED  = 0x0004
+)
+
+// 证书表中的一项(WIN_CERTIFICATE), Data不包括8字节的头.
+type Certificate struct {
+	Revision uint16
+	Type     uint16
+	Data     []byte
+}
+
+// 返回证书表, 没有时返回nil.
+func (p *PeFile) Certificates() []*Certificate {
+	return p.certificates
+}
+
+// 替换证书表, WriteTo把证书表按8字节对齐放在文件最后并修改SECURITY目录. nil表示删除.
+func (p *PeFile) SetCertificates(certs []*Certificate) {
+	p.certificates = certs
+}
+
+func (p *PeFile) RemoveCertificates() {
+	p.certificates = nil
+}
+
+// SECURITY目录中是文件偏移而不是rva. 证书表无效时忽略, 这些数据作为附加数据保留.
+func (p *PeFile) loadCertificates() (err error) {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return
+	}
+
+	dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_SECURITY)
+	if dir.VirtualAddress == 0 || dir.Size == 0 || dir.Size > maxCertificateTableSize {
+		return
+	}
+
+	data := make([]byte, dir.Size)
+	if n, err := p.r.ReadAt(data, int64(dir.VirtualAddress)); err != nil && err != io.EOF {
+		return err
+	} else if n != len(data) {
+		return nil
+	}
+
+	certs, err := ParseCertificateTable(data)
+	if err != nil {
+		return nil
+	}
+
+	p.certificates = certs
+	p.certificateOffset = int64(dir.VirtualAddress)
+	p.certificateSize = int64(dir.Size)
+	return nil
+}
+
+func ParseCertificateTable(data []byte) ([]*Certificate, error) {
+	var ret []*Certificate
+	for pos := 0; pos < len(data); {
+		if pos+winCertificateHeaderSize > len(data) {
+			return nil, ErrInvalidCertificate
+		}
+
+		size := int(binary.LittleEndian.Uint32(data[pos:]))
+		if size < winCertificateHeaderSize || pos+size > len(data) {
+			return nil, ErrInvalidCertificate
+		}
+
+		ret = append(ret, &Certificate{binary.LittleEndian.Uint16(data[pos+4:]), binary.LittleEndian.Uint16(data[pos+6:]),
+			append([]byte{}, data[pos+winCertificateHeaderSize:pos+size]...)})
+		pos += int(alignUp(uint32(size), 8))
+	}
+
+	return ret, nil
+}
+
+// 每一项按8字节对齐, 对齐的字节不计入dwLength.
+func CertificateTableBytes(certs []*Certificate) []byte {
+	var ret []byte
+	for _, c := range certs {
+		var header [winCertificateHeaderSize]byte
+		binary.LittleEndian.PutUint32(header[:], uint32(winCertificateHeaderSize+len(c.Data)))
+		binary.LittleEndian.PutUint16(header[4:], c.Revision)
+		binary.LittleEndian.PutUint16(header[6:], c.Type)
+		ret = append(append(ret, header[:]...), c.Data...)
+		ret = append(ret, make([]byte, int(alignUp(uint32(len(ret)), 8))-len(ret))...)
+	}
+
+	return ret
+}
+
+// 根据证书表之前的数据的结束位置修改SECURITY目录, 返回需要填充的字节数.
+func (p *PeFile) updateSecurityDirectory(end int64) (pad int, err error) {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return
+	}
+
+	dir := pe.DataDirectory{}
+	if size := len(CertificateTableBytes(p.certificates)); size > 0 {
+		pad = int(alignUp(uint32(end), 8) - uint32(end))
+		dir = pe.DataDirectory{VirtualAddress: uint32(end) + uint32(pad), Size: uint32(size)}
+	} else if h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_SECURITY) == dir {
+		return
+	}
+
+	err = h.SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_SECURITY, dir)
+	return
+}
+
+func (p *PeFile) writeCertificates(w io.Writer, pad int) (err error) {
+	if len(p.certificates) > 0 {
+		if _, err = w.Write(make([]byte, pad)); err == nil {
+			_, err = w.Write(CertificateTableBytes(p.certificates))
+		}
+	}
+
+	return
+}
+
+// 符号表和字符串表的大小.
+func (p *PeFile) symbolTableSize() int64 {
+	size := int64(len(p.symbols))
+	if p.symbols == nil {
+		size = int64(len(p.File.COFFSymbols)) * pe.COFFSymbolSize
+	}
+
+	if p.File.StringTable != nil {
+		size += int64(len(p.File.StringTable)) + 4
+	}
+
+	return size
+}
@@ -0,0 +1,242 @@
+package pefile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+var testSigningTime = time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+
+func testCertificate(t *testing.T, name string) (*x509.Certificate, *ecdsa.PrivateKey) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    testSigningTime.Add(-time.Hour),
+		NotAfter:     testSigningTime.Add(time.Hour * 24 * 365 * 100),
+		KeyUsage:     x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning, x509.ExtKeyUsageTimeStamping},
+		IsCA:         true, BasicConstraintsValid: true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+
+	cert, _ := x509.ParseCertificate(der)
+	return cert, key
+}
+
+func testAttribute(t *testing.T, oid asn1.ObjectIdentifier, values ...[]byte) []byte {
+	der, err := asn1.Marshal(attribute{oid, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(values, nil)}})
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	return der
+}
+
+func testMarshal(t *testing.T, v interface{}) []byte {
+	der, err := asn1.Marshal(v)
+	if err != nil {
+		t.Fatalf("Marshal failed: %v", err)
+	}
+
+	return der
+}
+
+// 生成SignedData, content是内容的DER, unauthenticated是未认证属性.
+func testSignedData(t *testing.T, contentType asn1.ObjectIdentifier, content []byte, cert *x509.Certificate, key crypto.Signer, unauthenticated ...[]byte) []byte {
+	var inner asn1.RawValue
+	asn1.Unmarshal(content, &inner)
+	digest := sha256.Sum256(inner.Bytes)
+	if contentType.Equal(oidTSTInfo) {
+		digest = sha256.Sum256(content)
+		content = testMarshal(t, content) //OCTET STRING
+	}
+
+	attrs := bytes.Join([][]byte{
+		testAttribute(t, oidContentType, testMarshal(t, contentType)),
+		testAttribute(t, oidSigningTime, testMarshal(t, testSigningTime)),
+		testAttribute(t, oidMessageDigest, testMarshal(t, digest[:])),
+	}, nil)
+	set := testMarshal(t, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: attrs})
+	hash := sha256.Sum256(set)
+	signature, err := key.Sign(rand.Reader, hash[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	sha := pkix.AlgorithmIdentifier{Algorithm: oidFromHash(crypto.SHA256), Parameters: asn1.NullRawValue}
+	si := signerInfo{
+		Version:                   1,
+		IssuerAndSerialNumber:     issuerAndSerial{asn1.RawValue{FullBytes: cert.RawIssuer}, cert.SerialNumber},
+		DigestAlgorithm:           sha,
+		AuthenticatedAttributes:   explicitTag(0, attrs),
+		DigestEncryptionAlgorithm: pkix.AlgorithmIdentifier{Algorithm: asn1.ObjectIdentifier{1, 2, 840, 10045, 4, 3, 2}},
+		EncryptedDigest:           signature,
+	}
+	if len(unauthenticated) > 0 {
+		si.UnauthenticatedAttributes = explicitTag(1, bytes.Join(unauthenticated, nil))
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{sha},
+		ContentInfo:      contentInfo{contentType, explicitTag(0, content)},
+		Certificates:     explicitTag(0, cert.Raw),
+		SignerInfos:      []signerInfo{si},
+	}
+
+	return testMarshal(t, contentInfo{oidSignedData, explicitTag(0, testMarshal(t, sd))})
+}
+
+func testAuthenticode(t *testing.T, digest []byte, unauthenticated ...[]byte) ([]byte, *x509.Certificate) {
+	cert, key := testCertificate(t, "pefile test")
+	content := testMarshal(t, spcIndirectDataContent{
+		Data:          spcAttributeTypeAndOptionalValue{Type: oidSpcPeImageData, Value: asn1.NullRawValue},
+		MessageDigest: digestInfo{pkix.AlgorithmIdentifier{Algorithm: oidFromHash(crypto.SHA256), Parameters: asn1.NullRawValue}, digest},
+	})
+
+	return testSignedData(t, oidSpcIndirectDataContent, content, cert, key, unauthenticated...), cert
+}
+
+func TestCertificates(t *testing.T) {
+	tsaCert, tsaKey := testCertificate(t, "pefile tsa")
+	info := testMarshal(t, tstInfo{1, asn1.ObjectIdentifier{1, 2, 3}, messageImprint{
+		pkix.AlgorithmIdentifier{Algorithm: oidFromHash(crypto.SHA256)}, []byte("imprint")}, big.NewInt(1), testSigningTime})
+	token := testSignedData(t, oidTSTInfo, info, tsaCert, tsaKey)
+
+	nested, _ := testAuthenticode(t, []byte("nested digest"))
+	signature, cert := testAuthenticode(t, []byte("digest"),
+		testAttribute(t, oidRFC3161CounterSign, token), testAttribute(t, oidNestedSignature, nested))
+
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if f.Certificates() != nil {
+		t.Fatalf("hello_gcc_exe should not have certificates")
+	}
+
+	f.SetOverlay([]byte("overlay"))
+	f.SetCertificates([]*Certificate{{WIN_CERT_REVISION_2_0, WIN_CERT_TYPE_PKCS_SIGNED_DATA, signature}})
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	data := buf.Bytes()
+	g, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	dir := g.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_SECURITY)
+	if dir.VirtualAddress%8 != 0 || int(dir.VirtualAddress+dir.Size) != len(data) {
+		t.Fatalf("security directory error: %+v", dir)
+	}
+
+	if o := g.Overlay(); o == nil || o.Size < 7 || o.Size > 14 { //包括证书表之前的填充
+		t.Fatalf("overlay error")
+	} else if b, _ := o.Data(); !bytes.HasPrefix(b, []byte("overlay")) || !isZero(b[7:]) {
+		t.Fatalf("overlay data error: %q", b)
+	}
+
+	certs := g.Certificates()
+	if len(certs) != 1 || certs[0].Type != WIN_CERT_TYPE_PKCS_SIGNED_DATA || !bytes.Equal(certs[0].Data, signature) {
+		t.Fatalf("certificates error")
+	}
+
+	//再次写出保持不变
+	var again bytes.Buffer
+	if err = g.WriteTo(&again); err != nil || !bytes.Equal(again.Bytes(), data) {
+		t.Fatalf("rewrite changed the file: %v", err)
+	}
+
+	sigs, err := g.Signatures()
+	if err != nil || len(sigs) != 1 {
+		t.Fatalf("Signatures failed: %v", err)
+	}
+
+	s := sigs[0]
+	if s.DigestAlgorithm != crypto.SHA256 || string(s.Digest) != "digest" || len(s.Certificates) != 1 || !bytes.Equal(s.Raw, signature) {
+		t.Fatalf("signature error: %+v", s)
+	}
+
+	if s.Signer.Certificate == nil || !s.Signer.Certificate.Equal(cert) || s.Signer.DigestAlgorithm != crypto.SHA256 ||
+		!s.Signer.SigningTime.Equal(testSigningTime) || len(s.Signer.MessageDigest) != sha256.Size {
+		t.Fatalf("signer error: %+v", s.Signer)
+	}
+
+	if len(s.Nested) != 1 || string(s.Nested[0].Digest) != "nested digest" {
+		t.Fatalf("nested signature error")
+	}
+
+	if len(s.Timestamps) != 1 || !s.Timestamps[0].RFC3161 || !s.Timestamps[0].Time.Equal(testSigningTime) ||
+		string(s.Timestamps[0].HashedMessage) != "imprint" || !s.Timestamps[0].Signer.Certificate.Equal(tsaCert) {
+		t.Fatalf("timestamp error: %+v", s.Timestamps)
+	}
+
+	g.RemoveCertificates()
+	buf.Reset()
+	if err = g.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	if g, err = New(bytes.NewReader(buf.Bytes())); err != nil || g.Certificates() != nil ||
+		g.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_SECURITY) != (pe.DataDirectory{}) {
+		t.Fatalf("certificates not removed: %v", err)
+	}
+
+	if _, err = ParseCertificateTable(CertificateTableBytes(certs)[:20]); err == nil {
+		t.Fatalf("ParseCertificateTable should fail with truncated data")
+	}
+
+	//证书表之后的数据并入附加数据, 写出时放在证书表之前
+	trailed := append(again.Bytes(), "trailer"...)
+	if g, err = New(bytes.NewReader(trailed)); err != nil || len(g.Certificates()) != 1 {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if o := g.Overlay(); o == nil {
+		t.Fatalf("overlay error")
+	} else if b, _ := o.Data(); !bytes.HasPrefix(b, []byte("overlay")) || !bytes.HasSuffix(b, []byte("trailer")) {
+		t.Fatalf("overlay data error: %q", b)
+	}
+
+	buf.Reset()
+	if err = g.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	dir = g.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_SECURITY)
+	if int(dir.VirtualAddress+dir.Size) != buf.Len() || !bytes.Contains(buf.Bytes()[:dir.VirtualAddress], []byte("trailer")) {
+		t.Fatalf("data after the certificate table should be moved before it: %+v", dir)
+	}
+
+	g.RemoveCertificates()
+	buf.Reset()
+	if err = g.WriteTo(&buf); err != nil || !bytes.HasSuffix(buf.Bytes(), []byte("trailer")) {
+		t.Fatalf("data after the certificate table lost without certificates: %v", err)
+	}
+}
@@ -0,0 +1,86 @@
+package pefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+)
+
+const checksumIndex = 20 + 64 //CheckSum在FileHeader之后, OptionalHeader中的偏移是64
+
+type WriteOptions struct {
+	UpdateChecksum bool //写出时重新计算并填写CheckSum
+}
+
+func (p *PeFile) WriteToWithOptions(w io.Writer, opts WriteOptions) (err error) {
+	if !opts.UpdateChecksum || p.File.OptionalHeader == nil {
+		return p.WriteTo(w)
+	}
+
+	var buf bytes.Buffer
+	if err = p.WriteTo(&buf); err != nil {
+		return
+	}
+
+	data := buf.Bytes()
+	sum := ComputeChecksum(data)
+	binary.LittleEndian.PutUint32(data[len(p.dosHeader)+checksumIndex:], sum)
+	p.OptionHeaderView().setCheckSum(sum)
+
+	_, err = buf.WriteTo(w)
+	return
+}
+
+// 和CheckSumMappedFile相同的算法, data是完整的文件内容.
+func ComputeChecksum(data []byte) uint32 {
+	skip := checksumOffset(data)
+	size := len(data)
+	sum := uint32(0)
+
+	for i := 0; i < size; i += 2 {
+		if i == skip || i == skip+2 {
+			continue
+		}
+
+		word := uint32(data[i])
+		if i+1 < size {
+			word |= uint32(data[i+1]) << 8
+		}
+
+		sum += word
+		sum = (sum & 0xffff) + (sum >> 16)
+	}
+
+	sum = (sum & 0xffff) + (sum >> 16)
+	return sum + uint32(size)
+}
+
+// 返回CheckSum字段在文件中的偏移, 不是映像文件时返回-1.
+func checksumOffset(data []byte) int {
+	if len(data) < dosHeaderSize || data[0] != 'M' || data[1] != 'Z' {
+		return -1
+	}
+
+	offset := int(binary.LittleEndian.Uint32(data[dosLfanewIndex:])) + 4
+	if offset+checksumIndex+4 > len(data) || !bytes.Equal(data[offset-4:offset], peSignature) {
+		return -1
+	}
+
+	return offset + checksumIndex
+}
+
+// 检查打开的文件中记录的CheckSum是否正确, CheckSum为0时返回false.
+func (p *PeFile) VerifyChecksum() (bool, error) {
+	data, err := p.readAll()
+	if err != nil {
+		return false, err
+	}
+
+	offset := checksumOffset(data)
+	if offset < 0 {
+		return false, nil
+	}
+
+	sum := binary.LittleEndian.Uint32(data[offset:])
+	return sum != 0 && sum == ComputeChecksum(data), nil
+}
@@ -0,0 +1,442 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+var ErrInvalidDebugDirectory = errors.New("pefile: invalid debug directory")
+
+const (
+	maxDebugEntries   = 0x100
+	codeViewRSDS      = "RSDS"
+	codeViewNB10      = "NB10"
+	vcFeatureSize     = 20
+	maxDebugEntrySize = 0x1000000
+)
+
+type DebugEntry struct {
+	ImageDebugDirectory
+	p *PeFile
+}
+
+// 返回调试数据, 没有映射到内存的数据按文件偏移读取.
+func (e *DebugEntry) Data() ([]byte, error) {
+	if e.SizeOfData > maxDebugEntrySize {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	if e.AddressOfRawData != 0 {
+		return e.p.ReadRVA(e.AddressOfRawData, e.SizeOfData)
+	}
+
+	if e.PointerToRawData == 0 || e.SizeOfData == 0 {
+		return nil, nil
+	}
+
+	data := make([]byte, e.SizeOfData)
+	if n, err := e.p.r.ReadAt(data, int64(e.PointerToRawData)); err != nil && err != io.EOF {
+		return nil, err
+	} else if n != len(data) {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	return data, nil
+}
+
+// 没有调试目录时返回nil, nil.
+func (p *PeFile) DebugDirectory() ([]*DebugEntry, error) {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return nil, nil
+	}
+
+	dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_DEBUG)
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return nil, nil
+	}
+
+	count := dir.Size / ImageDebugDirectorySize
+	if count > maxDebugEntries {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	data, err := p.ReadRVA(dir.VirtualAddress, count*ImageDebugDirectorySize)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := make([]*DebugEntry, count)
+	r := bytes.NewReader(data)
+	for i := range ret {
+		ret[i] = &DebugEntry{p: p}
+		binary.Read(r, binary.LittleEndian, &ret[i].ImageDebugDirectory)
+	}
+
+	return ret, nil
+}
+
+// 返回第一个CodeView项, 没有时返回nil, nil.
+func (p *PeFile) CodeView() (*CodeViewInfo, error) {
+	entries, err := p.DebugDirectory()
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if e.Type == IMAGE_DEBUG_TYPE_CODEVIEW {
+			return e.CodeView()
+		}
+	}
+
+	return nil, nil
+}
+
+// RSDS使用GUID, NB10使用Signature(时间戳).
+type CodeViewInfo struct {
+	Format    string //"RSDS"或"NB10"
+	GUID      [16]byte
+	Signature uint32
+	Offset    uint32
+	Age       uint32
+	PDBPath   string
+}
+
+func (e *DebugEntry) CodeView() (*CodeViewInfo, error) {
+	if e.Type != IMAGE_DEBUG_TYPE_CODEVIEW {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	data, err := e.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	return ParseCodeView(data)
+}
+
+func ParseCodeView(data []byte) (*CodeViewInfo, error) {
+	r := &templateReader{data: data}
+	ret := &CodeViewInfo{Format: string(r.bytes(4))}
+	switch ret.Format {
+	case codeViewRSDS:
+		copy(ret.GUID[:], r.bytes(16))
+	case codeViewNB10:
+		ret.Offset, ret.Signature = r.u32(), r.u32()
+	default:
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	ret.Age = r.u32()
+	if r.err {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	path := data[r.pos:]
+	if i := bytes.IndexByte(path, 0); i >= 0 {
+		path = path[:i]
+	}
+	ret.PDBPath = string(path)
+
+	return ret, nil
+}
+
+func (c *CodeViewInfo) Bytes() []byte {
+	w := &templateWriter{}
+	w.WriteString(c.Format)
+	if c.Format == codeViewNB10 {
+		w.put(c.Offset, c.Signature)
+	} else {
+		w.Write(c.GUID[:])
+	}
+
+	w.put(c.Age)
+	w.WriteString(c.PDBPath)
+	w.WriteByte(0)
+
+	return w.Bytes()
+}
+
+// 例如"{3F2504E0-4F89-11D3-9A0C-0305E82C3301}".
+func (c *CodeViewInfo) GUIDString() string {
+	g := c.GUID
+	le := binary.LittleEndian
+	return fmt.Sprintf("{%08X-%04X-%04X-%X-%X}", le.Uint32(g[:]), le.Uint16(g[4:]), le.Uint16(g[6:]), g[8:10], g[10:])
+}
+
+// 符号服务器的路径中使用的键, RSDS为GUID加Age, NB10为Signature加Age.
+func (c *CodeViewInfo) SymbolServerKey() string {
+	if c.Format == codeViewNB10 {
+		return fmt.Sprintf("%X%X", c.Signature, c.Age)
+	}
+
+	g := c.GUID
+	le := binary.LittleEndian
+	return fmt.Sprintf("%08X%04X%04X%X%X", le.Uint32(g[:]), le.Uint16(g[4:]), le.Uint16(g[6:]), g[8:], c.Age)
+}
+
+type PogoEntry struct {
+	RVA, Size uint32
+	Name      string
+}
+
+type PogoInfo struct {
+	Signature uint32 //例如"LTCG", "PGU\0"
+	Entries   []PogoEntry
+}
+
+func (e *DebugEntry) Pogo() (*PogoInfo, error) {
+	if e.Type != IMAGE_DEBUG_TYPE_POGO {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	data, err := e.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	r := &templateReader{data: data}
+	ret := &PogoInfo{Signature: r.u32()}
+	for !r.err && r.pos+8 < len(data) {
+		entry := PogoEntry{RVA: r.u32(), Size: r.u32()}
+		start := r.pos
+		for r.pos < len(data) && data[r.pos] != 0 {
+			r.pos++
+		}
+
+		entry.Name = string(data[start:r.pos])
+		r.pos++
+		r.align()
+		ret.Entries = append(ret.Entries, entry)
+	}
+
+	if r.err {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	return ret, nil
+}
+
+// 使用各种安全特性编译的对象文件的个数.
+type VCFeatureInfo struct {
+	PreVC11 uint32
+	CCpp    uint32
+	GS      uint32
+	SDL     uint32
+	GuardN  uint32
+}
+
+func (e *DebugEntry) VCFeature() (*VCFeatureInfo, error) {
+	if e.Type != IMAGE_DEBUG_TYPE_VC_FEATURE {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	data, err := e.Data()
+	if err != nil {
+		return nil, err
+	} else if len(data) < vcFeatureSize {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	ret := &VCFeatureInfo{}
+	binary.Read(bytes.NewReader(data), binary.LittleEndian, ret)
+	return ret, nil
+}
+
+// 返回IMAGE_DLLCHARACTERISTICS_EX_*.
+func (e *DebugEntry) ExDllCharacteristics() (uint32, error) {
+	if e.Type != IMAGE_DEBUG_TYPE_EX_DLLCHARACTERISTICS {
+		return 0, ErrInvalidDebugDirectory
+	}
+
+	data, err := e.Data()
+	if err != nil {
+		return 0, err
+	} else if len(data) < 4 {
+		return 0, ErrInvalidDebugDirectory
+	}
+
+	return binary.LittleEndian.Uint32(data), nil
+}
+
+// 返回确定性编译的哈希, 旧的链接器不写数据, 这时返回nil.
+func (e *DebugEntry) Repro() ([]byte, error) {
+	if e.Type != IMAGE_DEBUG_TYPE_REPRO {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	data, err := e.Data()
+	if err != nil || len(data) == 0 {
+		return nil, err
+	}
+
+	if len(data) < 4 || uint64(binary.LittleEndian.Uint32(data))+4 > uint64(len(data)) {
+		return nil, ErrInvalidDebugDirectory
+	}
+
+	return data[4 : 4+binary.LittleEndian.Uint32(data)], nil
+}
+
+// 把entries写回原来的调试目录, 后面多出的部分清零. 不能比原来的目录大.
+func (p *PeFile) SetDebugDirectory(entries []*DebugEntry) error {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return ErrNoOptionHeader
+	}
+
+	dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_DEBUG)
+	size := uint32(len(entries)) * ImageDebugDirectorySize
+	if size > dir.Size {
+		return ErrOutOfRange
+	}
+
+	var buf bytes.Buffer
+	for _, e := range entries {
+		binary.Write(&buf, binary.LittleEndian, &e.ImageDebugDirectory)
+	}
+	buf.Write(make([]byte, dir.Size-size))
+
+	if dir.Size > 0 {
+		if err := p.WriteRVA(dir.VirtualAddress, buf.Bytes()); err != nil {
+			return err
+		}
+	}
+
+	if len(entries) == 0 {
+		dir = pe.DataDirectory{}
+	}
+	dir.Size = size
+
+	return h.SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_DEBUG, dir)
+}
+
+// 删除match返回true的调试项, 返回删除的个数. removeData为true时同时清零节中的调试数据.
+// 没有剩下CodeView时设置IMAGE_FILE_DEBUG_STRIPPED.
+func (p *PeFile) RemoveDebugEntries(removeData bool, match func(e *DebugEntry) bool) (int, error) {
+	entries, err := p.DebugDirectory()
+	if err != nil || len(entries) == 0 {
+		return 0, err
+	}
+
+	var keep []*DebugEntry
+	codeView := false
+	for _, e := range entries {
+		if !match(e) {
+			keep = append(keep, e)
+			codeView = codeView || e.Type == IMAGE_DEBUG_TYPE_CODEVIEW
+			continue
+		}
+
+		if removeData && e.AddressOfRawData != 0 && e.SizeOfData > 0 {
+			if err = p.WriteRVA(e.AddressOfRawData, make([]byte, e.SizeOfData)); err != nil {
+				return 0, err
+			}
+		}
+	}
+
+	removed := len(entries) - len(keep)
+	if removed == 0 {
+		return 0, nil
+	}
+
+	if err = p.SetDebugDirectory(keep); err != nil {
+		return 0, err
+	}
+
+	if !codeView {
+		p.File.FileHeader.Characteristics |= IMAGE_FILE_DEBUG_STRIPPED
+	}
+
+	return removed, nil
+}
+
+// 修改第一个CodeView项, 新的数据不能比原来的大, 多出的部分清零.
+func (p *PeFile) SetCodeView(c *CodeViewInfo) error {
+	entries, err := p.DebugDirectory()
+	if err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if e.Type != IMAGE_DEBUG_TYPE_CODEVIEW {
+			continue
+		}
+
+		data := c.Bytes()
+		if e.AddressOfRawData == 0 || uint32(len(data)) > e.SizeOfData {
+			return ErrOutOfRange
+		}
+
+		if err = p.WriteRVA(e.AddressOfRawData, append(data, make([]byte, int(e.SizeOfData)-len(data))...)); err != nil {
+			return err
+		}
+
+		e.SizeOfData = uint32(len(data))
+		return p.SetDebugDirectory(entries)
+	}
+
+	return ErrInvalidDebugDirectory
+}
+
+// 修改全部调试项的时间戳, 0表示清除.
+func (p *PeFile) SetDebugTimestamps(timestamp uint32) error {
+	entries, err := p.DebugDirectory()
+	if err != nil || len(entries) == 0 {
+		return err
+	}
+
+	for _, e := range entries {
+		e.TimeDateStamp = timestamp
+	}
+
+	return p.SetDebugDirectory(entries)
+}
+
+// 写出时在输出中修改的4字节.
+type filePatch struct {
+	offset int64
+	value  uint32
+}
+
+// 节的文件位置改变后, 调试项中的PointerToRawData需要修正. 返回在输出中修正的位置, 不修改p.
+// 调试目录无效时不修正.
+func (p *PeFile) debugPointerPatches(header []pe.SectionHeader32) (patches []filePatch) {
+	entries, err := p.DebugDirectory()
+	if err != nil || len(entries) == 0 {
+		return
+	}
+
+	//rva在输出文件中的位置
+	fileOffset := func(rva, size uint32) (int64, bool) {
+		for _, s := range header {
+			if rva >= s.VirtualAddress && uint64(rva)+uint64(size) <= uint64(s.VirtualAddress)+uint64(s.SizeOfRawData) {
+				return int64(s.PointerToRawData) + int64(rva-s.VirtualAddress), true
+			}
+		}
+
+		return 0, false
+	}
+
+	dir := p.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_DEBUG)
+	for i, e := range entries {
+		if e.AddressOfRawData == 0 || e.PointerToRawData == 0 {
+			continue
+		}
+
+		pos, ok := fileOffset(e.AddressOfRawData, 1)
+		if !ok || pos == int64(e.PointerToRawData) {
+			continue
+		}
+
+		field := dir.VirtualAddress + uint32(i)*ImageDebugDirectorySize + 24 //PointerToRawData
+		if offset, ok := fileOffset(field, 4); ok {
+			patches = append(patches, filePatch{offset, uint32(pos)})
+		}
+	}
+
+	return
+}
@@ -0,0 +1,237 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"io/ioutil"
+	"testing"
+)
+
+var testCodeView = &CodeViewInfo{
+	Format:  "RSDS",
+	GUID:    [16]byte{0xe0, 0x04, 0x25, 0x3f, 0x89, 0x4f, 0xd3, 0x11, 0x9a, 0x0c, 0x03, 0x05, 0xe8, 0x2c, 0x33, 0x01},
+	Age:     2,
+	PDBPath: `C:\build\hello\Release\hello.pdb`,
+}
+
+// 添加包含CodeView, VC_FEATURE, EX_DLLCHARACTERISTICS和REPRO的调试目录.
+func addTestDebugDirectory(t *testing.T, f *PeFile) {
+	data := make([]byte, 0x200)
+	f.AddSection(".dbg", data, IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+	va := f.mySections[len(f.mySections)-1].virtualAddress
+
+	cv := testCodeView.Bytes()
+	copy(data[0x100:], cv)
+	binary.LittleEndian.PutUint32(data[0x180:], 1)
+	binary.LittleEndian.PutUint32(data[0x184:], 20)
+	binary.LittleEndian.PutUint32(data[0x190:], IMAGE_DLLCHARACTERISTICS_EX_CET_COMPAT)
+	binary.LittleEndian.PutUint32(data[0x1a0:], 4)
+	copy(data[0x1a4:], "hash")
+
+	entries := []ImageDebugDirectory{
+		{TimeDateStamp: 0x5b5577b0, Type: IMAGE_DEBUG_TYPE_CODEVIEW, SizeOfData: uint32(len(cv)), AddressOfRawData: va + 0x100},
+		{TimeDateStamp: 0x5b5577b0, Type: IMAGE_DEBUG_TYPE_VC_FEATURE, SizeOfData: 20, AddressOfRawData: va + 0x180},
+		{TimeDateStamp: 0x5b5577b0, Type: IMAGE_DEBUG_TYPE_EX_DLLCHARACTERISTICS, SizeOfData: 4, AddressOfRawData: va + 0x190},
+		{Type: IMAGE_DEBUG_TYPE_REPRO, SizeOfData: 8, AddressOfRawData: va + 0x1a0},
+	}
+
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, entries)
+	copy(data, buf.Bytes())
+
+	dir := pe.DataDirectory{VirtualAddress: va, Size: uint32(buf.Len())}
+	if err := f.OptionHeaderView().SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_DEBUG, dir); err != nil {
+		t.Fatalf("SetDataDirectory failed: %v", err)
+	}
+}
+
+func TestDebugDirectory(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	entries, err := f.DebugDirectory()
+	if err != nil || len(entries) != 1 || entries[0].Type != IMAGE_DEBUG_TYPE_POGO || entries[0].TimeDateStamp != 0x5b5577b0 {
+		t.Fatalf("DebugDirectory failed: %v", err)
+	}
+
+	pogo, err := entries[0].Pogo()
+	if err != nil || len(pogo.Entries) == 0 {
+		t.Fatalf("Pogo failed: %v", err)
+	}
+
+	if e := pogo.Entries[0]; e.RVA != 0x1000 || e.Size != 0x1237b || e.Name != ".text$mn" {
+		t.Fatalf("pogo entry error: %+v", e)
+	}
+
+	if e := pogo.Entries[len(pogo.Entries)-1]; e.Name != ".bss" {
+		t.Fatalf("pogo entry error: %+v", e)
+	}
+
+	if _, err = entries[0].CodeView(); err == nil {
+		t.Fatalf("CodeView should fail with POGO entry")
+	}
+
+	addTestDebugDirectory(t, f)
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	g, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if entries, err = g.DebugDirectory(); err != nil || len(entries) != 4 {
+		t.Fatalf("DebugDirectory failed: %v", err)
+	}
+
+	cv, err := g.CodeView()
+	if err != nil || cv == nil || *cv != *testCodeView {
+		t.Fatalf("CodeView failed: %v %+v", err, cv)
+	}
+
+	if s := cv.GUIDString(); s != "{3F2504E0-4F89-11D3-9A0C-0305E82C3301}" {
+		t.Fatalf("GUIDString error: %v", s)
+	}
+
+	if s := cv.SymbolServerKey(); s != "3F2504E04F8911D39A0C0305E82C33012" {
+		t.Fatalf("SymbolServerKey error: %v", s)
+	}
+
+	if vc, err := entries[1].VCFeature(); err != nil || vc.PreVC11 != 1 || vc.CCpp != 20 {
+		t.Fatalf("VCFeature failed: %v %+v", err, vc)
+	}
+
+	if ex, err := entries[2].ExDllCharacteristics(); err != nil || ex != IMAGE_DLLCHARACTERISTICS_EX_CET_COMPAT {
+		t.Fatalf("ExDllCharacteristics failed: %v", err)
+	}
+
+	if hash, err := entries[3].Repro(); err != nil || string(hash) != "hash" {
+		t.Fatalf("Repro failed: %v", err)
+	}
+
+	nb10 := &CodeViewInfo{Format: "NB10", Signature: 0x3b5a6c1d, Age: 1, PDBPath: "hello.pdb"}
+	if c, err := ParseCodeView(nb10.Bytes()); err != nil || *c != *nb10 || c.SymbolServerKey() != "3B5A6C1D1" {
+		t.Fatalf("NB10 failed: %v %+v", err, c)
+	}
+}
+
+func TestStripDebugDirectory(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	//WriteTo按节的位置修正PointerToRawData
+	entries, _ := f.DebugDirectory()
+	pos := entries[0].PointerToRawData
+	entries[0].PointerToRawData = 0x1234
+	if err = f.SetDebugDirectory(entries); err != nil {
+		t.Fatalf("SetDebugDirectory failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	g, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if entries, err = g.DebugDirectory(); err != nil || entries[0].PointerToRawData != pos {
+		t.Fatalf("PointerToRawData not fixed: %v", err)
+	}
+
+	//只修改输出, 不修改f
+	if entries, err = f.DebugDirectory(); err != nil || entries[0].PointerToRawData != 0x1234 {
+		t.Fatalf("WriteTo changed the debug directory: %v", err)
+	}
+
+	//调试目录无效时不修正, WriteTo照常写出
+	dir := f.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_DEBUG)
+	f.OptionHeaderView().SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_DEBUG, pe.DataDirectory{VirtualAddress: 0x1000, Size: (maxDebugEntries + 1) * ImageDebugDirectorySize})
+	if err = f.WriteTo(ioutil.Discard); err != nil {
+		t.Fatalf("WriteTo failed with invalid debug directory: %v", err)
+	}
+	f.OptionHeaderView().SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_DEBUG, dir)
+
+	//修改的位置分在两次写入中
+	var out bytes.Buffer
+	src := make([]byte, 8)
+	pw := &patchWriter{w: &out, patches: []filePatch{{2, 0x44332211}}}
+	pw.Write(src[:4])
+	pw.Write(src[4:])
+	if !bytes.Equal(out.Bytes(), []byte{0, 0, 0x11, 0x22, 0x33, 0x44, 0, 0}) || !isZero(src) {
+		t.Fatalf("patchWriter error: % x", out.Bytes())
+	}
+
+	addTestDebugDirectory(t, f)
+	va := f.mySections[len(f.mySections)-1].virtualAddress
+
+	long := *testCodeView
+	long.PDBPath += ".long"
+	if err = f.SetCodeView(&long); err != ErrOutOfRange {
+		t.Fatalf("SetCodeView should fail with longer path: %v", err)
+	}
+
+	cv := &CodeViewInfo{Format: "RSDS", GUID: [16]byte{1, 2, 3}, Age: 1, PDBPath: "hello.pdb"}
+	if err = f.SetCodeView(cv); err != nil {
+		t.Fatalf("SetCodeView failed: %v", err)
+	}
+
+	if err = f.SetDebugTimestamps(0); err != nil {
+		t.Fatalf("SetDebugTimestamps failed: %v", err)
+	}
+
+	n, err := f.RemoveDebugEntries(true, func(e *DebugEntry) bool { return e.Type == IMAGE_DEBUG_TYPE_VC_FEATURE })
+	if err != nil || n != 1 {
+		t.Fatalf("RemoveDebugEntries failed: %v", err)
+	}
+
+	buf.Reset()
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	if g, err = New(bytes.NewReader(buf.Bytes())); err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if entries, err = g.DebugDirectory(); err != nil || len(entries) != 3 || g.File.Characteristics&IMAGE_FILE_DEBUG_STRIPPED != 0 {
+		t.Fatalf("DebugDirectory failed: %v", err)
+	}
+
+	for _, e := range entries {
+		if e.TimeDateStamp != 0 || e.Type == IMAGE_DEBUG_TYPE_VC_FEATURE {
+			t.Fatalf("debug entry error: %+v", e.ImageDebugDirectory)
+		}
+	}
+
+	if c, err := g.CodeView(); err != nil || *c != *cv {
+		t.Fatalf("CodeView failed: %v %+v", err, c)
+	}
+
+	if data, err := g.ReadRVA(va+0x180, 20); err != nil || !isZero(data) {
+		t.Fatalf("VC_FEATURE data not removed: %v", err)
+	}
+
+	if n, err = g.RemoveDebugEntries(true, func(*DebugEntry) bool { return true }); err != nil || n != 3 {
+		t.Fatalf("RemoveDebugEntries failed: %v", err)
+	}
+
+	if entries, err = g.DebugDirectory(); err != nil || entries != nil || g.File.Characteristics&IMAGE_FILE_DEBUG_STRIPPED == 0 {
+		t.Fatalf("debug directory not removed: %v", err)
+	}
+
+	if data, err := g.ReadRVA(va, 0x200); err != nil || !isZero(data) {
+		t.Fatalf("debug data not removed: %v", err)
+	}
+}
@@ -0,0 +1,216 @@
+package pefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrInvalidDialog = errors.New("pefile: invalid dialog template")
+
+type Dialog struct {
+	Extended       bool //DLGTEMPLATEEX
+	HelpID         uint32
+	Style, ExStyle uint32
+	X, Y, CX, CY   int16
+	Menu, Class    ResourceID //ResourceID{}表示没有
+	Title          string
+	FontSize       uint16 //Style包含DS_SETFONT时有效
+	FontWeight     uint16 //只用于DLGTEMPLATEEX
+	FontItalic     bool
+	FontCharset    uint8
+	FontName       string
+	Controls       []*DialogControl
+}
+
+type DialogControl struct {
+	HelpID         uint32
+	Style, ExStyle uint32
+	X, Y, CX, CY   int16
+	ID             uint32 //DLGTEMPLATE中只有16位
+	Class, Title   ResourceID
+	Data           []byte
+}
+
+// 按顺序读取模板, 出错后返回零值.
+type templateReader struct {
+	data []byte
+	pos  int
+	err  bool
+}
+
+func (r *templateReader) bytes(n int) []byte {
+	if r.err || n < 0 || r.pos+n > len(r.data) {
+		r.err = true
+		return make([]byte, n)
+	}
+
+	r.pos += n
+	return r.data[r.pos-n : r.pos]
+}
+
+func (r *templateReader) u8() uint8   { return r.bytes(1)[0] }
+func (r *templateReader) u16() uint16 { return binary.LittleEndian.Uint16(r.bytes(2)) }
+func (r *templateReader) u32() uint32 { return binary.LittleEndian.Uint32(r.bytes(4)) }
+
+func (r *templateReader) align() {
+	if pos := int(alignUp(uint32(r.pos), 4)); pos <= len(r.data) {
+		r.pos = pos
+	} else {
+		r.pos = len(r.data)
+	}
+}
+
+func (r *templateReader) sz() string {
+	start := r.pos
+	for !r.err && r.u16() != 0 {
+	}
+
+	if r.err {
+		return ""
+	}
+	return decodeUTF16(r.data[start : r.pos-2])
+}
+
+// 0x0000表示没有, 0xffff后面是ID, 否则是字符串.
+func (r *templateReader) szOrOrd() ResourceID {
+	if r.pos+2 <= len(r.data) {
+		switch binary.LittleEndian.Uint16(r.data[r.pos:]) {
+		case 0:
+			r.pos += 2
+			return ResourceID{}
+		case 0xffff:
+			r.pos += 2
+			return ResID(uint32(r.u16()))
+		}
+	}
+
+	return ResName(r.sz())
+}
+
+type templateWriter struct {
+	bytes.Buffer
+}
+
+func (w *templateWriter) put(v ...interface{}) {
+	for _, i := range v {
+		binary.Write(w, binary.LittleEndian, i)
+	}
+}
+
+func (w *templateWriter) align() {
+	for w.Len()%4 != 0 {
+		w.WriteByte(0)
+	}
+}
+
+func (w *templateWriter) sz(s string) {
+	w.Write(encodeUTF16(s + "\x00"))
+}
+
+func (w *templateWriter) szOrOrd(id ResourceID) {
+	if id.IsNamed() {
+		w.sz(id.Name)
+	} else if id.ID == 0 {
+		w.put(uint16(0))
+	} else {
+		w.put(uint16(0xffff), uint16(id.ID))
+	}
+}
+
+// 解析RT_DIALOG, 支持DLGTEMPLATE和DLGTEMPLATEEX.
+func ParseDialog(data []byte) (*Dialog, error) {
+	r := &templateReader{data: data}
+	d := &Dialog{}
+	var count uint16
+	if len(data) >= 4 && binary.LittleEndian.Uint16(data) == 1 && binary.LittleEndian.Uint16(data[2:]) == DLGTEMPLATEEX_SIGN {
+		d.Extended = true
+		r.pos = 4
+		d.HelpID, d.ExStyle, d.Style = r.u32(), r.u32(), r.u32()
+	} else {
+		d.Style, d.ExStyle = r.u32(), r.u32()
+	}
+
+	count = r.u16()
+	d.X, d.Y, d.CX, d.CY = int16(r.u16()), int16(r.u16()), int16(r.u16()), int16(r.u16())
+	d.Menu, d.Class, d.Title = r.szOrOrd(), r.szOrOrd(), r.sz()
+	if d.Style&DS_SETFONT != 0 {
+		d.FontSize = r.u16()
+		if d.Extended {
+			d.FontWeight = r.u16()
+			d.FontItalic = r.u8() != 0
+			d.FontCharset = r.u8()
+		}
+		d.FontName = r.sz()
+	}
+
+	for i := 0; i < int(count) && !r.err; i++ {
+		r.align()
+		c := &DialogControl{}
+		if d.Extended {
+			c.HelpID, c.ExStyle, c.Style = r.u32(), r.u32(), r.u32()
+		} else {
+			c.Style, c.ExStyle = r.u32(), r.u32()
+		}
+
+		c.X, c.Y, c.CX, c.CY = int16(r.u16()), int16(r.u16()), int16(r.u16()), int16(r.u16())
+		if d.Extended {
+			c.ID = r.u32()
+		} else {
+			c.ID = uint32(r.u16())
+		}
+
+		c.Class, c.Title = r.szOrOrd(), r.szOrOrd()
+		if size := int(r.u16()); size > 0 {
+			c.Data = append([]byte{}, r.bytes(size)...)
+		}
+		d.Controls = append(d.Controls, c)
+	}
+
+	if r.err {
+		return nil, ErrInvalidDialog
+	}
+
+	return d, nil
+}
+
+func (d *Dialog) Bytes() []byte {
+	w := &templateWriter{}
+	if d.Extended {
+		w.put(uint16(1), uint16(DLGTEMPLATEEX_SIGN), d.HelpID, d.ExStyle, d.Style)
+	} else {
+		w.put(d.Style, d.ExStyle)
+	}
+
+	w.put(uint16(len(d.Controls)), d.X, d.Y, d.CX, d.CY)
+	w.szOrOrd(d.Menu)
+	w.szOrOrd(d.Class)
+	w.sz(d.Title)
+	if d.Style&DS_SETFONT != 0 {
+		w.put(d.FontSize)
+		if d.Extended {
+			italic := uint8(0)
+			if d.FontItalic {
+				italic = 1
+			}
+			w.put(d.FontWeight, italic, d.FontCharset)
+		}
+		w.sz(d.FontName)
+	}
+
+	for _, c := range d.Controls {
+		w.align()
+		if d.Extended {
+			w.put(c.HelpID, c.ExStyle, c.Style, c.X, c.Y, c.CX, c.CY, c.ID)
+		} else {
+			w.put(c.Style, c.ExStyle, c.X, c.Y, c.CX, c.CY, uint16(c.ID))
+		}
+
+		w.szOrOrd(c.Class)
+		w.szOrOrd(c.Title)
+		w.put(uint16(len(c.Data)))
+		w.Write(c.Data)
+	}
+
+	return w.Bytes()
+}
@@ -0,0 +1,51 @@
+package pefile
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestDialog(t *testing.T) {
+	d := &Dialog{
+		Style: 0x80c800c0 | DS_SETFONT, X: 10, Y: 20, CX: 200, CY: 100, Title: "About",
+		FontSize: 8, FontName: "MS Shell Dlg",
+		Controls: []*DialogControl{
+			{Style: 0x50010001, X: 140, Y: 80, CX: 50, CY: 14, ID: 1, Class: ResID(0x80), Title: ResName("OK")},
+			{Style: 0x50000003, X: 7, Y: 7, CX: 21, CY: 20, ID: 0xffff, Class: ResID(0x82), Title: ResID(128)},
+			{Style: 0x50000000, X: 7, Y: 30, CX: 100, CY: 12, ID: 1000, Class: ResName("SysLink"), Title: ResName("<a>link</a>"), Data: []byte{1, 2}},
+		},
+	}
+
+	data := d.Bytes()
+	n, err := ParseDialog(data)
+	if err != nil {
+		t.Fatalf("ParseDialog failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(n, d) || bytes.Compare(n.Bytes(), data) != 0 {
+		t.Fatalf("dialog round trip failed: %+v", n)
+	}
+
+	//"About"之后是字体, 第一个控件按4字节对齐
+	if data[18] != 0 || data[20] != 0 || data[22] != 'A' || len(data)%2 != 0 {
+		t.Fatalf("dialog header error")
+	}
+
+	if _, err = ParseDialog(data[:len(data)-4]); err == nil {
+		t.Fatalf("ParseDialog should fail with truncated data")
+	}
+
+	d.Extended, d.HelpID, d.FontWeight, d.FontItalic, d.FontCharset = true, 7, 400, true, 1
+	d.Menu, d.Class = ResName("MAINMENU"), ResID(0x8002)
+	d.Controls[0].ID, d.Controls[0].HelpID = 0x12345, 9
+
+	data = d.Bytes()
+	if n, err = ParseDialog(data); err != nil {
+		t.Fatalf("ParseDialog failed: %v", err)
+	}
+
+	if !reflect.DeepEqual(n, d) || bytes.Compare(n.Bytes(), data) != 0 {
+		t.Fatalf("dialogex round trip failed: %+v", n)
+	}
+}
@@ -0,0 +1,90 @@
+package pefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+const (
+	dosHeaderSize  = 0x40
+	dosLfanewIndex = 0x3c
+)
+
+var ErrInvalidDosHeader = errors.New("pefile: invalid dos header")
+
+var peSignature = []byte{'P', 'E', 0, 0}
+
+func (p *PeFile) loadDosHeader() (err error) {
+	var head [dosHeaderSize]byte
+	if _, err = p.r.ReadAt(head[:], 0); err != nil || head[0] != 'M' || head[1] != 'Z' {
+		return nil //obj文件没有dos头
+	}
+
+	lfanew := binary.LittleEndian.Uint32(head[dosLfanewIndex:])
+	header := make([]byte, lfanew+4)
+	if _, err = p.r.ReadAt(header, 0); err == nil {
+		p.dosHeader = header
+	}
+
+	return
+}
+
+// 返回从文件开始到PE签名结束的全部数据, 包括dos stub和rich头.
+func (p *PeFile) DosHeader() []byte {
+	return append([]byte(nil), p.dosHeader...)
+}
+
+func (p *PeFile) SetDosHeader(header []byte) error {
+	if err := checkDosHeader(header); err != nil {
+		return err
+	}
+
+	p.dosHeader = append([]byte(nil), header...)
+	return nil
+}
+
+// 返回dos头(0x40字节)之后到PE签名之前的数据.
+func (p *PeFile) DosStub() []byte {
+	if len(p.dosHeader) < dosHeaderSize+4 {
+		return nil
+	}
+
+	return append([]byte(nil), p.dosHeader[dosHeaderSize:len(p.dosHeader)-4]...)
+}
+
+func (p *PeFile) SetDosStub(stub []byte) {
+	head := p.dosHeader
+	if len(head) < dosHeaderSize {
+		head = peHeader80
+	}
+
+	size := dosHeaderSize + len(stub)
+	if size%8 != 0 {
+		size = size - size%8 + 8
+	}
+
+	header := make([]byte, size, size+4)
+	copy(header, head[:dosHeaderSize])
+	copy(header[dosHeaderSize:], stub)
+	binary.LittleEndian.PutUint32(header[dosLfanewIndex:], uint32(size))
+	p.dosHeader = append(header, peSignature...)
+}
+
+// 使用默认的dos头和dos stub, 不带rich头.
+func (p *PeFile) ResetDosHeader() {
+	p.dosHeader = append([]byte(nil), peHeader80...)
+}
+
+func checkDosHeader(header []byte) error {
+	if len(header) < dosHeaderSize+4 || header[0] != 'M' || header[1] != 'Z' {
+		return ErrInvalidDosHeader
+	}
+
+	lfanew := binary.LittleEndian.Uint32(header[dosLfanewIndex:])
+	if lfanew%4 != 0 || int(lfanew)+4 != len(header) || !bytes.Equal(header[lfanew:], peSignature) {
+		return ErrInvalidDosHeader
+	}
+
+	return nil
+}
@@ -0,0 +1,177 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"sort"
+	"strconv"
+)
+
+var ErrInvalidExportDirectory = errors.New("pefile: invalid export directory")
+
+const maxExports = 0x10000
+
+// 同一个序号有多个名字时, 每个名字一项.
+type Export struct {
+	Ordinal   uint32
+	Name      string //按序号导出时为空
+	RVA       uint32 //转发导出时为0
+	Forwarder string //转发导出, 例如"NTDLL.RtlAllocateHeap"
+}
+
+type ExportDirectory struct {
+	Directory ImageExportDirectory
+	Name      string
+	Exports   []Export
+	byRVA     []int //按RVA排序的非转发导出
+}
+
+func (p *PeFile) Exports() (*ExportDirectory, error) {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return nil, nil
+	}
+
+	dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_EXPORT)
+	if dir.VirtualAddress == 0 {
+		return nil, nil
+	} else if dir.Size < ImageExportDirectorySize {
+		return nil, ErrInvalidExportDirectory
+	}
+
+	data, err := p.ReadRVA(dir.VirtualAddress, ImageExportDirectorySize)
+	if err != nil {
+		return nil, err
+	}
+
+	ret := &ExportDirectory{}
+	d := &ret.Directory
+	if err = binary.Read(bytes.NewReader(data), binary.LittleEndian, d); err != nil {
+		return nil, ErrInvalidExportDirectory
+	}
+
+	if d.NumberOfFunctions > maxExports || d.NumberOfNames > maxExports { //一个序号可以有多个名字
+		return nil, ErrInvalidExportDirectory
+	}
+
+	if d.Name != 0 {
+		if ret.Name, err = p.readStringRVA(d.Name); err != nil {
+			return nil, err
+		}
+	}
+
+	functions, err := p.ReadRVA(d.AddressOfFunctions, d.NumberOfFunctions*4)
+	if err != nil {
+		return nil, err
+	}
+
+	names := make([][]string, d.NumberOfFunctions)
+	if d.NumberOfNames > 0 {
+		nameRVAs, err := p.ReadRVA(d.AddressOfNames, d.NumberOfNames*4)
+		if err != nil {
+			return nil, err
+		}
+
+		ordinals, err := p.ReadRVA(d.AddressOfNameOrdinals, d.NumberOfNames*2)
+		if err != nil {
+			return nil, err
+		}
+
+		for i := uint32(0); i < d.NumberOfNames; i++ {
+			index := binary.LittleEndian.Uint16(ordinals[i*2:])
+			if uint32(index) >= d.NumberOfFunctions {
+				return nil, ErrInvalidExportDirectory
+			}
+
+			name, err := p.readStringRVA(binary.LittleEndian.Uint32(nameRVAs[i*4:]))
+			if err != nil {
+				return nil, err
+			}
+			names[index] = append(names[index], name)
+		}
+	}
+
+	for i := uint32(0); i < d.NumberOfFunctions; i++ {
+		rva := binary.LittleEndian.Uint32(functions[i*4:])
+		if rva == 0 { //序号没有使用
+			continue
+		}
+
+		e := Export{Ordinal: d.Base + i, RVA: rva}
+		forward := rva >= dir.VirtualAddress && rva-dir.VirtualAddress < dir.Size
+		if forward {
+			e.RVA = 0
+			if e.Forwarder, err = p.readStringRVA(rva); err != nil {
+				return nil, err
+			}
+		}
+
+		if len(names[i]) == 0 {
+			names[i] = []string{""}
+		}
+
+		for _, name := range names[i] {
+			e.Name = name
+			if !forward {
+				ret.byRVA = append(ret.byRVA, len(ret.Exports))
+			}
+			ret.Exports = append(ret.Exports, e)
+		}
+	}
+
+	sort.SliceStable(ret.byRVA, func(i, j int) bool {
+		return ret.Exports[ret.byRVA[i]].RVA < ret.Exports[ret.byRVA[j]].RVA
+	})
+
+	return ret, nil
+}
+
+// 返回rva所在的导出函数名和相对函数开始的偏移, 没有名字的导出返回"#序号".
+func (d *ExportDirectory) Lookup(rva uint32) (name string, offset uint32, ok bool) {
+	i := sort.Search(len(d.byRVA), func(i int) bool { return d.Exports[d.byRVA[i]].RVA > rva })
+	if i == 0 {
+		return
+	}
+
+	e := &d.Exports[d.byRVA[i-1]]
+	first := i - 1
+	for first > 0 && d.Exports[d.byRVA[first-1]].RVA == e.RVA {
+		first--
+	}
+
+	for j := first; j < i; j++ { //相同地址优先使用第一个有名字的导出
+		if cur := &d.Exports[d.byRVA[j]]; cur.Name != "" {
+			e = cur
+			break
+		}
+	}
+
+	name = e.Name
+	if name == "" {
+		name = "#" + strconv.Itoa(int(e.Ordinal))
+	}
+
+	return name, rva - e.RVA, true
+}
+
+func (d *ExportDirectory) ByOrdinal(ordinal uint32) *Export {
+	for i := range d.Exports {
+		if d.Exports[i].Ordinal == ordinal {
+			return &d.Exports[i]
+		}
+	}
+
+	return nil
+}
+
+func (d *ExportDirectory) ByName(name string) *Export {
+	for i := range d.Exports {
+		if d.Exports[i].Name == name {
+			return &d.Exports[i]
+		}
+	}
+
+	return nil
+}
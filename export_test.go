@@ -0,0 +1,125 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"testing"
+)
+
+// 在新加的节中构造导出表: 序号5 alpha和alpha2, 6未使用, 7 beta和8(同一地址), 9 fwd转发.
+func addTestExports(t *testing.T, f *PeFile) {
+	data := make([]byte, 0x100)
+	f.AddSection(".edata", data, IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+	va := f.mySections[len(f.mySections)-1].virtualAddress
+
+	d := ImageExportDirectory{TimeDateStamp: 0x5f000000, Name: va + 0x80, Base: 5, NumberOfFunctions: 5, NumberOfNames: 4,
+		AddressOfFunctions: va + 0x28, AddressOfNames: va + 0x40, AddressOfNameOrdinals: va + 0x50}
+	var buf bytes.Buffer
+	binary.Write(&buf, binary.LittleEndian, &d)
+	binary.Write(&buf, binary.LittleEndian, []uint32{0x1010, 0, 0x1020, 0x1020, va + 0x9f})
+	copy(data, buf.Bytes())
+
+	for i, v := range []uint32{va + 0x89, va + 0x8f, va + 0x96, va + 0x9b} {
+		binary.LittleEndian.PutUint32(data[0x40+i*4:], v)
+	}
+
+	for i, v := range []uint16{0, 0, 2, 4} {
+		binary.LittleEndian.PutUint16(data[0x50+i*2:], v)
+	}
+	copy(data[0x80:], "test.dll\x00alpha\x00alpha2\x00beta\x00fwd\x00NTDLL.RtlAllocateHeap\x00")
+
+	if err := f.OptionHeaderView().SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_EXPORT, pe.DataDirectory{VirtualAddress: va, Size: 0x100}); err != nil {
+		t.Fatalf("SetDataDirectory failed: %v", err)
+	}
+}
+
+func TestExports(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if d, err := f.Exports(); d != nil || err != nil {
+		t.Fatalf("hello_vc_exe should not have exports")
+	}
+
+	//目录比ImageExportDirectory小
+	f.OptionHeaderView().SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_EXPORT, pe.DataDirectory{VirtualAddress: 0x1000, Size: 8})
+	if _, err := f.Exports(); err != ErrInvalidExportDirectory {
+		t.Fatalf("expect ErrInvalidExportDirectory, got %v", err)
+	}
+
+	addTestExports(t, f)
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	n, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	for _, v := range []*PeFile{f, n} {
+		d, err := v.Exports()
+		if err != nil || d == nil {
+			t.Fatalf("Exports failed: %v", err)
+		}
+
+		should := []Export{
+			{5, "alpha", 0x1010, ""},
+			{5, "alpha2", 0x1010, ""},
+			{7, "beta", 0x1020, ""},
+			{8, "", 0x1020, ""},
+			{9, "fwd", 0, "NTDLL.RtlAllocateHeap"},
+		}
+
+		if d.Name != "test.dll" || d.Directory.Base != 5 || d.Directory.TimeDateStamp != 0x5f000000 || len(d.Exports) != len(should) {
+			t.Fatalf("export directory error: %+v", d)
+		}
+
+		for i, e := range should {
+			if d.Exports[i] != e {
+				t.Fatalf("export %v should: %+v, get: %+v", i, e, d.Exports[i])
+			}
+		}
+
+		if name, off, ok := d.Lookup(0x1018); !ok || name != "alpha" || off != 8 {
+			t.Fatalf("Lookup error: %v %v %v", name, off, ok)
+		}
+
+		if name, off, ok := d.Lookup(0x1020); !ok || name != "beta" || off != 0 {
+			t.Fatalf("Lookup error: %v %v %v", name, off, ok)
+		}
+
+		if _, _, ok := d.Lookup(0x1000); ok {
+			t.Fatalf("Lookup should fail before the first export")
+		}
+
+		if e := d.ByOrdinal(8); e == nil || e.RVA != 0x1020 {
+			t.Fatalf("ByOrdinal failed")
+		}
+
+		if e := d.ByName("alpha2"); e == nil || e.Ordinal != 5 {
+			t.Fatalf("ByName failed")
+		}
+
+		if e := d.ByName("fwd"); e == nil || e.Forwarder == "" {
+			t.Fatalf("ByName failed")
+		}
+	}
+
+	//名字比函数多: 只保留序号5的alpha和alpha2
+	counts := make([]byte, 8)
+	binary.LittleEndian.PutUint32(counts, 1)
+	binary.LittleEndian.PutUint32(counts[4:], 2)
+	if err = f.WriteRVA(f.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_EXPORT).VirtualAddress+20, counts); err != nil {
+		t.Fatalf("WriteRVA failed: %v", err)
+	}
+
+	if d, err := f.Exports(); err != nil || len(d.Exports) != 2 || d.Exports[0].Name != "alpha" || d.Exports[1].Name != "alpha2" {
+		t.Fatalf("more names than functions failed: %v", err)
+	}
+}
@@ -4,15 +4,29 @@ import (
 	"bytes"
 	"debug/pe"
 	"encoding/binary"
+	"errors"
 	"github.com/mtlicz/container"
 	"io"
+	"io/ioutil"
+	"os"
 )
 
+var ErrHeaderOverflow = errors.New("pefile: headers overlap the first section")
+
 type PeFile struct {
 	File                            *pe.File
 	mySections                      []customSection
 	va                              *container.Scope
 	sectionAlignment, fileAlignment uint32
+	r                               io.ReaderAt
+	closer                          io.Closer
+	symbols                         []byte //原始符号表, debug/pe读取辅助符号时会丢掉部分字节.
+	dosHeader                       []byte //从文件开始到PE签名结束的原始数据.
+	overlay                         *Overlay
+	sectionData                     map[*pe.Section][]byte //修改过的节数据
+	certificates                    []*Certificate
+	certificateOffset               int64 //证书表在打开的文件中的偏移和大小, 证书表不属于附加数据
+	certificateSize                 int64
 }
 
 type OptionalHeader struct {
@@ -121,14 +135,8 @@ func (p *PeFile) OptionHeader() *OptionalHeader {
 }
 
 func (p *PeFile) IsOptionHeader64() bool {
-	f := p.File
-	if f.OptionalHeader != nil {
-		return f.Machine == pe.IMAGE_FILE_MACHINE_AMD64 ||
-			f.Machine == pe.IMAGE_FILE_MACHINE_ARM64 ||
-			f.Machine == pe.IMAGE_FILE_MACHINE_IA64
-	}
-
-	return false
+	_, ok := p.File.OptionalHeader.(*pe.OptionalHeader64)
+	return ok
 }
 
 func (p *PeFile) WriteTo(w io.Writer) (err error) {
@@ -136,42 +144,61 @@ func (p *PeFile) WriteTo(w io.Writer) (err error) {
 	fileAlignment := p.fileAlignment
 	size := uint32(binary.Size(fileHeader))
 	size += uint32(p.File.SizeOfOptionalHeader)
-	if p.File.OptionalHeader != nil {
-		size += uint32(len(peHeader))
-	}
+	size += uint32(len(p.dosHeader))
 
 	size += uint32(int(fileHeader.NumberOfSections) * binary.Size(pe.SectionHeader32{}))
 	if fileAlignment > 1 && size%fileAlignment != 0 {
 		size = size - size%fileAlignment + fileAlignment
 	}
 
-	if p.File.OptionalHeader != nil {
-		switch p.File.OptionalHeader.(type) {
-		case *pe.OptionalHeader32:
-			h := p.File.OptionalHeader.(*pe.OptionalHeader32)
-			h.SizeOfHeaders = size
-		case *pe.OptionalHeader64:
-			h := p.File.OptionalHeader.(*pe.OptionalHeader64)
-			h.SizeOfHeaders = size
+	if h := p.OptionHeaderView(); h != nil {
+		if first := p.firstSectionAddress(); first != 0 && size > first {
+			return ErrHeaderOverflow
 		}
+
+		h.setSizeOfHeaders(size)
 	}
 
-	sections := buildSectionRaw(p.File, size, fileAlignment)
+	sections, err := p.buildSectionRaw(size)
+	if err != nil {
+		return
+	}
+	var patches []filePatch
+	if p.File.OptionalHeader != nil {
+		patches = p.debugPointerPatches(sections.header)
+	}
 	if fileHeader.NumberOfSymbols > 0 {
 		fileHeader.PointerToSymbolTable = sections.rawDataEnd
 	}
 
-	headers := []interface{}{peHeader, &fileHeader, p.File.OptionalHeader, sections.header}
-	if p.File.OptionalHeader == nil { //obj hasn't option header
-		headers[0] = nil
+	end := int64(sections.rawDataEnd) + p.symbolTableSize()
+	if p.overlay != nil {
+		end += p.overlay.Size
+	}
+
+	pad, err := p.updateSecurityDirectory(end)
+	if err != nil {
+		return
 	}
 
+	headers := []interface{}{p.dosHeader, &fileHeader, p.File.OptionalHeader, sections.header}
+
 	var buf bytes.Buffer
-	for _, v := range headers {
+	for i, v := range headers {
 		if v != nil {
+			from := buf.Len()
 			if err = binary.Write(&buf, binary.LittleEndian, v); err != nil {
 				return
 			}
+
+			if i == 2 { //NumberOfRvaAndSizes小于16时, OptionalHeader比结构体小
+				optionSize := from + int(fileHeader.SizeOfOptionalHeader)
+				if optionSize < buf.Len() {
+					buf.Truncate(optionSize)
+				} else if optionSize > buf.Len() {
+					buf.Write(make([]byte, optionSize-buf.Len()))
+				}
+			}
 		}
 	}
 
@@ -179,26 +206,81 @@ func (p *PeFile) WriteTo(w io.Writer) (err error) {
 		data := make([]byte, int(size)-buf.Len())
 		buf.Write(data)
 	}
+	if len(patches) > 0 {
+		w = &patchWriter{w: w, patches: patches}
+	}
+
 	if _, err = buf.WriteTo(w); err == nil {
 		if err = p.writeSection(w, sections.data, fileAlignment); err == nil {
-			err = p.writeSymbolAndStringTable(w)
+			if err = p.writeSymbolAndStringTable(w); err == nil {
+				if err = p.writeOverlay(w); err == nil {
+					err = p.writeCertificates(w, pad)
+				}
+			}
 		}
 	}
 
 	return
 }
 
+func (p *PeFile) firstSectionAddress() uint32 {
+	first := uint32(0)
+	for _, s := range p.File.Sections {
+		if first == 0 || s.VirtualAddress < first {
+			first = s.VirtualAddress
+		}
+	}
+
+	for _, s := range p.mySections {
+		if first == 0 || s.virtualAddress < first {
+			first = s.virtualAddress
+		}
+	}
+
+	return first
+}
+
+// 按输出中的位置修改写出的数据, 不修改传入的数据.
+type patchWriter struct {
+	w       io.Writer
+	pos     int64
+	patches []filePatch
+}
+
+func (pw *patchWriter) Write(b []byte) (n int, err error) {
+	end := pw.pos + int64(len(b))
+	data, copied := b, false
+	for _, v := range pw.patches {
+		var value [4]byte
+		binary.LittleEndian.PutUint32(value[:], v.value)
+		for i := int64(0); i < 4; i++ { //可能分在两次写入中
+			if off := v.offset + i; off >= pw.pos && off < end {
+				if !copied {
+					data, copied = append([]byte(nil), b...), true
+				}
+				data[off-pw.pos] = value[i]
+			}
+		}
+	}
+
+	n, err = pw.w.Write(data)
+	pw.pos += int64(n)
+	return
+}
+
 func (p *PeFile) writeSection(w io.Writer, data []sectionRawData, alignment uint32) (err error) {
 	if alignment < 16 {
 		alignment = 16
 	}
+	if len(data) == 0 {
+		return
+	}
+
 	blank := make([]byte, alignment)
-	sections := p.File.Sections
 	from := data[0].pos
 	var size int64
 
 	for _, d := range data {
-		v := sections[d.index]
 		if from < d.pos {
 			if err = writeBlank(w, blank, int(d.pos-from)); err != nil {
 				break
@@ -207,18 +289,26 @@ func (p *PeFile) writeSection(w io.Writer, data []sectionRawData, alignment uint
 			from = d.pos
 		}
 
-		if d.data == nil {
-			r := v.Open()
-			size, err = io.Copy(w, r)
-			if err != nil {
-				break
-			}
-		} else {
-			if err = binary.Write(w, binary.LittleEndian, d.data); err != nil {
-				break
+		switch v := d.data.(type) {
+		case nil:
+			if data, ok := p.sectionData[d.section]; ok {
+				var written int
+				written, err = w.Write(data)
+				size = int64(written)
+			} else {
+				size, err = io.Copy(w, d.section.Open())
 			}
+		case []byte:
+			var written int
+			written, err = w.Write(v)
+			size = int64(written)
+		default:
+			err = binary.Write(w, binary.LittleEndian, v)
 			size = int64(d.size)
 		}
+		if err != nil {
+			break
+		}
 
 		sizeAdd := int(d.size) - int(size)
 		if sizeAdd > 0 {
@@ -274,7 +364,9 @@ func searchString(str string, st []byte) uint32 {
 }
 
 func (p *PeFile) writeSymbolAndStringTable(w io.Writer) (err error) {
-	if p.File.COFFSymbols != nil {
+	if p.symbols != nil {
+		_, err = w.Write(p.symbols)
+	} else if p.File.COFFSymbols != nil {
 		err = binary.Write(w, binary.LittleEndian, p.File.COFFSymbols)
 	}
 
@@ -288,10 +380,43 @@ func (p *PeFile) writeSymbolAndStringTable(w io.Writer) (err error) {
 	return
 }
 
+// 返回原始文件的全部内容.
+func (p *PeFile) readAll() ([]byte, error) {
+	size, err := readerSize(p.r)
+	if err != nil {
+		return nil, err
+	}
+
+	data := make([]byte, size)
+	if _, err = p.r.ReadAt(data, 0); err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	return data, nil
+}
+
+func readerSize(r io.ReaderAt) (int64, error) {
+	switch v := r.(type) {
+	case interface{ Size() int64 }:
+		return v.Size(), nil
+	case interface{ Stat() (os.FileInfo, error) }:
+		if fi, err := v.Stat(); err == nil {
+			return fi.Size(), nil
+		}
+	}
+
+	return io.Copy(ioutil.Discard, io.NewSectionReader(r, 0, 1<<62))
+}
+
 func (p *PeFile) Close() {
 	if p.File != nil {
 		p.File.Close()
 	}
+
+	if p.closer != nil {
+		p.closer.Close()
+		p.closer = nil
+	}
 }
 
 func (p *PeFile) AddSection(name string, data []byte, characteristics uint32) {
@@ -306,26 +431,29 @@ func (p *PeFile) AddSection(name string, data []byte, characteristics uint32) {
 }
 
 func (p *PeFile) addSectionAllocAddress(need int) (addr, size uint32) {
-	addr = uint32(p.va.Alloc(uint64(need)))
 	size = uint32(need)
-	align := p.sectionAlignment
-
-	if align > 1 && size%align != 0 {
-		size = size - size%align + align
+	alloc := p.alignSize(size, false)
+	if alloc == 0 {
+		alloc = p.alignSize(1, false)
 	}
 
+	addr = uint32(p.va.Alloc(uint64(alloc)))
 	return
 }
 
 func (p *PeFile) RemoveSection(name string) bool {
+	return p.removeSection(func(n string, va uint32) bool { return n == name })
+}
+
+func (p *PeFile) removeSection(match func(name string, va uint32) bool) bool {
 	i := 0
 	s := p.mySections
 	found := false
 
 	for ; i < len(s); i++ {
-		if s[i].name == name {
+		if match(s[i].name, s[i].virtualAddress) {
 			if p.va != nil {
-				p.va.Remove(uint64(s[i].virtualAddress), uint64(s[i].virtualSize))
+				p.va.Remove(uint64(s[i].virtualAddress), uint64(p.alignSize(s[i].virtualSize, false)))
 			}
 
 			p.mySections = append(s[:i], s[i+1:]...)
@@ -337,10 +465,11 @@ func (p *PeFile) RemoveSection(name string) bool {
 	if !found {
 		s := p.File.Sections
 		for i = 0; i < len(s); i++ {
-			if s[i].Name == name {
+			if match(s[i].Name, s[i].VirtualAddress) {
 				if p.va != nil {
-					p.va.Remove(uint64(s[i].VirtualAddress), uint64(s[i].VirtualSize))
+					p.va.Remove(uint64(s[i].VirtualAddress), uint64(p.alignSize(virtualSize(s[i]), false)))
 				}
+				delete(p.sectionData, s[i])
 				p.File.Sections = append(s[:i], s[i+1:]...)
 				found = true
 				break
@@ -362,13 +491,21 @@ func (p *PeFile) alignSize(size uint32, file bool) uint32 {
 		align = p.sectionAlignment
 	}
 
-	if size%align != 0 {
+	if align > 1 && size%align != 0 {
 		size = size - size%align + align
 	}
 
 	return size
 }
 
+func virtualSize(s *pe.Section) uint32 {
+	if s.VirtualSize == 0 {
+		return s.Size
+	}
+
+	return s.VirtualSize
+}
+
 func (p *PeFile) sectionChanged() {
 	code := uint32(0)
 	data := uint32(0)
@@ -418,22 +555,9 @@ func (p *PeFile) sectionChanged() {
 			}
 		}
 
-		switch p.File.OptionalHeader.(type) {
-		case *pe.OptionalHeader32:
-			h := p.File.OptionalHeader.(*pe.OptionalHeader32)
-			h.SizeOfCode = code
-			h.SizeOfInitializedData = data
-			h.SizeOfUninitializedData = bss
-			h.SizeOfImage = size
-			h.CheckSum = 0
-		case *pe.OptionalHeader64:
-			h := p.File.OptionalHeader.(*pe.OptionalHeader64)
-			h.SizeOfCode = code
-			h.SizeOfInitializedData = data
-			h.SizeOfUninitializedData = bss
-			h.SizeOfImage = size
-			h.CheckSum = 0
-		}
+		h := p.OptionHeaderView()
+		h.setSizes(code, data, bss, size)
+		h.setCheckSum(0)
 	}
 }
 
@@ -441,58 +565,73 @@ func (p *PeFile) ResetFileAlignment(align uint32) {
 	if align != p.fileAlignment {
 		p.fileAlignment = align
 
-		if p.File.OptionalHeader != nil {
-			switch p.File.OptionalHeader.(type) {
-			case *pe.OptionalHeader32:
-				h := p.File.OptionalHeader.(*pe.OptionalHeader32)
-				h.FileAlignment = align
-			case *pe.OptionalHeader64:
-				h := p.File.OptionalHeader.(*pe.OptionalHeader64)
-				h.FileAlignment = align
-			}
-
+		if h := p.OptionHeaderView(); h != nil {
+			h.setFileAlignment(align)
 			p.sectionChanged()
 		}
 	}
 }
 
-func (p *PeFile) load() {
-	if p.File.OptionalHeader != nil {
+func (p *PeFile) load() (err error) {
+	if h := p.OptionHeaderView(); h != nil {
+		p.fileAlignment = h.FileAlignment()
+		p.sectionAlignment = h.SectionAlignment()
+
 		p.va = container.NewScope()
+		p.va.Insert(0, uint64(p.alignSize(h.SizeOfHeaders(), false)))
 		for _, s := range p.File.Sections {
-			p.va.Insert(uint64(s.VirtualAddress), uint64(s.VirtualSize))
-		}
-
-		if p.IsOptionHeader64() {
-			h := p.File.OptionalHeader.(*pe.OptionalHeader64)
-			p.fileAlignment = h.FileAlignment
-			p.sectionAlignment = h.SectionAlignment
-		} else {
-			h := p.File.OptionalHeader.(*pe.OptionalHeader32)
-			p.fileAlignment = h.FileAlignment
-			p.sectionAlignment = h.SectionAlignment
+			p.va.Insert(uint64(s.VirtualAddress), uint64(p.alignSize(virtualSize(s), false)))
 		}
 	} else {
 		p.fileAlignment = 1
 		p.sectionAlignment = 1
 	}
+
+	if err = p.loadDosHeader(); err != nil {
+		return
+	}
+
+	if fh := p.File.FileHeader; fh.PointerToSymbolTable > 0 && fh.NumberOfSymbols > 0 {
+		symbols := make([]byte, int(fh.NumberOfSymbols)*pe.COFFSymbolSize)
+		if _, err = p.r.ReadAt(symbols, int64(fh.PointerToSymbolTable)); err != nil {
+			return
+		}
+		p.symbols = symbols
+	}
+
+	if err = p.loadCertificates(); err != nil {
+		return
+	}
+
+	return p.loadOverlay()
 }
 
 func New(r io.ReaderAt) (*PeFile, error) {
-	return toFile(pe.NewFile(r))
-}
+	f, err := pe.NewFile(r)
+	if err != nil {
+		return nil, err
+	}
 
-func Open(name string) (*PeFile, error) {
-	return toFile(pe.Open(name))
+	ret := &PeFile{File: f, r: r}
+	if err = ret.load(); err != nil {
+		return nil, err
+	}
+
+	return ret, nil
 }
 
-func toFile(f *pe.File, err error) (*PeFile, error) {
-	if err == nil {
-		ret := &PeFile{File: f}
-		ret.load()
+func Open(name string) (*PeFile, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
 
-		return ret, nil
-	} else {
+	ret, err := New(f)
+	if err != nil {
+		f.Close()
 		return nil, err
 	}
+
+	ret.closer = f
+	return ret, nil
 }
@@ -7,15 +7,15 @@ import (
 )
 
 type rebuildItem struct {
-	name       string
-	headerType int
+	name   string
+	lfanew int
 }
 
 var rebuildItems = []rebuildItem{
 	{"hello_gcc_exe", 0x80},
-	{"hello_gcc_obj", 0x80},
+	{"hello_gcc_obj", 0},
 	{"hello_vc_exe", 0x100},
-	{"hello_vc_obj", 0xb8},
+	{"hello_vc_obj", 0},
 }
 
 func TestExeWriteTo(t *testing.T) {
@@ -30,13 +30,8 @@ func TestExeWriteTo(t *testing.T) {
 			defer f.Close()
 			var buf bytes.Buffer
 
-			switch v.headerType {
-			case 0x80:
-				peHeader = peHeader80
-			case 0xb8:
-				peHeader = peHeaderB8
-			case 0x100:
-				peHeader = peHeader100
+			if size := len(f.DosHeader()); v.lfanew != 0 && size != v.lfanew+4 || v.lfanew == 0 && size != 0 {
+				t.Fatalf("%v dos header size error: %v", v.name, size)
 			}
 
 			if err = f.WriteTo(&buf); err != nil {
@@ -58,3 +53,217 @@ func TestExeWriteTo(t *testing.T) {
 		}
 	}
 }
+
+func TestAddSection(t *testing.T) {
+	payload := []byte("pefile custom section payload")
+
+	for _, v := range rebuildItems {
+		f, err := Open("testdata/" + v.name)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer f.Close()
+
+		count := len(f.File.Sections)
+		f.AddSection(".longname", payload[:8], IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+
+		var buf bytes.Buffer
+		if f.File.OptionalHeader != nil && f.File.NumberOfSymbols == 0 { //没有符号表的映像不能使用长节名
+			if err = f.WriteTo(&buf); err != ErrSectionName {
+				t.Fatalf("%v expect ErrSectionName, got %v", v.name, err)
+			}
+			f.RemoveSection(".longname")
+			f.AddSection(".short", payload[:8], IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+			buf.Reset()
+		}
+		f.AddSection(".test", payload, IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+
+		if err = f.WriteTo(&buf); err != nil {
+			t.Fatalf("%v WriteTo failed: %v", v.name, err)
+		}
+
+		n, err := New(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("%v reopen failed: %v", v.name, err)
+		}
+
+		if len(n.File.Sections) != count+2 || int(n.File.NumberOfSections) != count+2 {
+			t.Fatalf("%v section count should: %v, get: %v", v.name, count+2, len(n.File.Sections))
+		}
+
+		s := n.File.Section(".test")
+		if s == nil {
+			t.Fatalf("%v added section not found", v.name)
+		}
+
+		data, err := s.Data()
+		if err != nil || !bytes.HasPrefix(data, payload) {
+			t.Fatalf("%v added section data error: %v", v.name, err)
+		}
+
+		for i, o := range f.File.Sections {
+			a, _ := o.Data()
+			b, _ := n.File.Sections[i].Data()
+			if o.Name != n.File.Sections[i].Name || bytes.Compare(a, b) != 0 {
+				t.Fatalf("%v section %v changed", v.name, o.Name)
+			}
+		}
+
+		if n.File.OptionalHeader != nil {
+			h := n.OptionHeader()
+			end := uint32(0)
+			for _, cur := range n.File.Sections {
+				if cur.VirtualAddress%h.SectionAlignment != 0 || cur.VirtualAddress < end {
+					t.Fatalf("%v section %v bad address %x", v.name, cur.Name, cur.VirtualAddress)
+				}
+				end = n.alignSize(cur.VirtualAddress+virtualSize(cur), false)
+				if cur.Size > 0 && cur.Offset%h.FileAlignment != 0 {
+					t.Fatalf("%v section %v bad offset %x", v.name, cur.Name, cur.Offset)
+				}
+			}
+
+			if h.SizeOfImage != end {
+				t.Fatalf("%v SizeOfImage should: %x, get: %x", v.name, end, h.SizeOfImage)
+			}
+		}
+	}
+}
+
+func TestDosStub(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	stub := []byte("\x0e\x1f\xba\x0e\x00\xb4\x09\xcd\x21\xb8\x01\x4c\xcd\x21custom stub$")
+	f.SetDosStub(stub)
+	if !bytes.HasPrefix(f.DosStub(), stub) || len(f.DosHeader())%8 != 4 {
+		t.Fatalf("SetDosStub failed")
+	}
+
+	if err = f.SetDosHeader(f.DosHeader()[:0x40]); err != ErrInvalidDosHeader {
+		t.Fatalf("SetDosHeader should fail, get: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	n, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if bytes.Compare(n.DosHeader(), f.DosHeader()) != 0 || n.File.NumberOfSections != f.File.NumberOfSections {
+		t.Fatalf("dos header not preserved")
+	}
+
+	n.ResetDosHeader()
+	if bytes.Compare(n.DosHeader(), peHeader80) != 0 {
+		t.Fatalf("ResetDosHeader failed")
+	}
+}
+
+func TestChecksum(t *testing.T) {
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if ok, err := f.VerifyChecksum(); !ok || err != nil {
+		t.Fatalf("VerifyChecksum failed: %v", err)
+	}
+
+	f.AddSection(".test", []byte("checksum"), IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+	if h := f.OptionHeader(); h.CheckSum != 0 {
+		t.Fatalf("CheckSum should be reset")
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteToWithOptions(&buf, WriteOptions{UpdateChecksum: true}); err != nil {
+		t.Fatalf("WriteToWithOptions failed: %v", err)
+	}
+
+	n, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if ok, err := n.VerifyChecksum(); !ok || err != nil || n.OptionHeader().CheckSum != f.OptionHeader().CheckSum {
+		t.Fatalf("CheckSum error after WriteToWithOptions: %v", err)
+	}
+
+	v, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer v.Close()
+
+	if ok, err := v.VerifyChecksum(); ok || err != nil {
+		t.Fatalf("zero CheckSum should not verify: %v", err)
+	}
+}
+
+func TestOverlay(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	payload := []byte("installer payload appended to the image")
+	size := len(data)
+	data = append(data, payload...)
+
+	f, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	o := f.Overlay()
+	if o == nil || o.Offset != int64(size) || o.Size != int64(len(payload)) {
+		t.Fatalf("overlay not found")
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil || bytes.Compare(buf.Bytes(), data) != 0 {
+		t.Fatalf("overlay not preserved: %v", err)
+	}
+
+	f.AddSection(".test", payload, IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+	f.SetOverlay(payload[:9])
+	buf.Reset()
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	n, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if o = n.Overlay(); o == nil || o.Offset != int64(buf.Len()-9) {
+		t.Fatalf("overlay offset error")
+	} else if d, err := o.Data(); err != nil || bytes.Compare(d, payload[:9]) != 0 {
+		t.Fatalf("overlay data error: %v", err)
+	}
+
+	n.RemoveOverlay()
+	if n.Overlay() != nil {
+		t.Fatalf("RemoveOverlay failed")
+	}
+
+	for _, v := range rebuildItems {
+		f, err := Open("testdata/" + v.name)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+
+		if f.Overlay() != nil {
+			t.Fatalf("%v should not have overlay", v.name)
+		}
+		f.Close()
+	}
+}
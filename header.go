@@ -0,0 +1,349 @@
+package pefile
+
+import (
+	"debug/pe"
+	"errors"
+)
+
+var (
+	ErrNoOptionHeader = errors.New("pefile: file has no optional header")
+	ErrOutOfRange     = errors.New("pefile: value out of range")
+)
+
+const imageBaseAlignment = 0x10000
+
+// 直接读写File.OptionalHeader, 屏蔽32位和64位头的差别.
+type OptionHeaderView struct {
+	p   *PeFile
+	h32 *pe.OptionalHeader32
+	h64 *pe.OptionalHeader64
+}
+
+// obj文件没有OptionalHeader, 返回nil.
+func (p *PeFile) OptionHeaderView() *OptionHeaderView {
+	switch h := p.File.OptionalHeader.(type) {
+	case *pe.OptionalHeader32:
+		return &OptionHeaderView{p: p, h32: h}
+	case *pe.OptionalHeader64:
+		return &OptionHeaderView{p: p, h64: h}
+	}
+
+	return nil
+}
+
+func (v *OptionHeaderView) Is64() bool {
+	return v.h64 != nil
+}
+
+func (v *OptionHeaderView) Magic() uint16 {
+	if v.h64 != nil {
+		return v.h64.Magic
+	}
+
+	return v.h32.Magic
+}
+
+func (v *OptionHeaderView) ImageBase() uint64 {
+	if v.h64 != nil {
+		return v.h64.ImageBase
+	}
+
+	return uint64(v.h32.ImageBase)
+}
+
+// ImageBase需要64K对齐, 32位映像整个映像不能超过4G.
+func (v *OptionHeaderView) SetImageBase(base uint64) error {
+	if base%imageBaseAlignment != 0 {
+		return ErrOutOfRange
+	}
+
+	if v.h64 != nil {
+		if base+uint64(v.h64.SizeOfImage) < base {
+			return ErrOutOfRange
+		}
+		v.h64.ImageBase = base
+	} else {
+		if base+uint64(v.h32.SizeOfImage) > 1<<32 {
+			return ErrOutOfRange
+		}
+		v.h32.ImageBase = uint32(base)
+	}
+
+	return nil
+}
+
+func (v *OptionHeaderView) AddressOfEntryPoint() uint32 {
+	if v.h64 != nil {
+		return v.h64.AddressOfEntryPoint
+	}
+
+	return v.h32.AddressOfEntryPoint
+}
+
+// 入口地址为0表示没有入口(dll), 否则必须在映像范围内.
+func (v *OptionHeaderView) SetAddressOfEntryPoint(rva uint32) error {
+	if rva != 0 && rva >= v.SizeOfImage() {
+		return ErrOutOfRange
+	}
+
+	if v.h64 != nil {
+		v.h64.AddressOfEntryPoint = rva
+	} else {
+		v.h32.AddressOfEntryPoint = rva
+	}
+
+	return nil
+}
+
+func (v *OptionHeaderView) Subsystem() uint16 {
+	if v.h64 != nil {
+		return v.h64.Subsystem
+	}
+
+	return v.h32.Subsystem
+}
+
+func (v *OptionHeaderView) SetSubsystem(subsystem uint16) {
+	if v.h64 != nil {
+		v.h64.Subsystem = subsystem
+	} else {
+		v.h32.Subsystem = subsystem
+	}
+}
+
+func (v *OptionHeaderView) DllCharacteristics() uint16 {
+	if v.h64 != nil {
+		return v.h64.DllCharacteristics
+	}
+
+	return v.h32.DllCharacteristics
+}
+
+func (v *OptionHeaderView) SetDllCharacteristics(c uint16) {
+	if v.h64 != nil {
+		v.h64.DllCharacteristics = c
+	} else {
+		v.h32.DllCharacteristics = c
+	}
+}
+
+func (v *OptionHeaderView) StackSize() (reserve, commit uint64) {
+	if v.h64 != nil {
+		return v.h64.SizeOfStackReserve, v.h64.SizeOfStackCommit
+	}
+
+	return uint64(v.h32.SizeOfStackReserve), uint64(v.h32.SizeOfStackCommit)
+}
+
+func (v *OptionHeaderView) SetStackSize(reserve, commit uint64) error {
+	if commit > reserve || (v.h64 == nil && reserve > 0xffffffff) {
+		return ErrOutOfRange
+	}
+
+	if v.h64 != nil {
+		v.h64.SizeOfStackReserve, v.h64.SizeOfStackCommit = reserve, commit
+	} else {
+		v.h32.SizeOfStackReserve, v.h32.SizeOfStackCommit = uint32(reserve), uint32(commit)
+	}
+
+	return nil
+}
+
+func (v *OptionHeaderView) HeapSize() (reserve, commit uint64) {
+	if v.h64 != nil {
+		return v.h64.SizeOfHeapReserve, v.h64.SizeOfHeapCommit
+	}
+
+	return uint64(v.h32.SizeOfHeapReserve), uint64(v.h32.SizeOfHeapCommit)
+}
+
+func (v *OptionHeaderView) SetHeapSize(reserve, commit uint64) error {
+	if commit > reserve || (v.h64 == nil && reserve > 0xffffffff) {
+		return ErrOutOfRange
+	}
+
+	if v.h64 != nil {
+		v.h64.SizeOfHeapReserve, v.h64.SizeOfHeapCommit = reserve, commit
+	} else {
+		v.h32.SizeOfHeapReserve, v.h32.SizeOfHeapCommit = uint32(reserve), uint32(commit)
+	}
+
+	return nil
+}
+
+func (v *OptionHeaderView) OperatingSystemVersion() (major, minor uint16) {
+	if v.h64 != nil {
+		return v.h64.MajorOperatingSystemVersion, v.h64.MinorOperatingSystemVersion
+	}
+
+	return v.h32.MajorOperatingSystemVersion, v.h32.MinorOperatingSystemVersion
+}
+
+func (v *OptionHeaderView) SetOperatingSystemVersion(major, minor uint16) {
+	if v.h64 != nil {
+		v.h64.MajorOperatingSystemVersion, v.h64.MinorOperatingSystemVersion = major, minor
+	} else {
+		v.h32.MajorOperatingSystemVersion, v.h32.MinorOperatingSystemVersion = major, minor
+	}
+}
+
+func (v *OptionHeaderView) SubsystemVersion() (major, minor uint16) {
+	if v.h64 != nil {
+		return v.h64.MajorSubsystemVersion, v.h64.MinorSubsystemVersion
+	}
+
+	return v.h32.MajorSubsystemVersion, v.h32.MinorSubsystemVersion
+}
+
+func (v *OptionHeaderView) SetSubsystemVersion(major, minor uint16) {
+	if v.h64 != nil {
+		v.h64.MajorSubsystemVersion, v.h64.MinorSubsystemVersion = major, minor
+	} else {
+		v.h32.MajorSubsystemVersion, v.h32.MinorSubsystemVersion = major, minor
+	}
+}
+
+func (v *OptionHeaderView) ImageVersion() (major, minor uint16) {
+	if v.h64 != nil {
+		return v.h64.MajorImageVersion, v.h64.MinorImageVersion
+	}
+
+	return v.h32.MajorImageVersion, v.h32.MinorImageVersion
+}
+
+func (v *OptionHeaderView) SetImageVersion(major, minor uint16) {
+	if v.h64 != nil {
+		v.h64.MajorImageVersion, v.h64.MinorImageVersion = major, minor
+	} else {
+		v.h32.MajorImageVersion, v.h32.MinorImageVersion = major, minor
+	}
+}
+
+func (v *OptionHeaderView) NumberOfRvaAndSizes() uint32 {
+	if v.h64 != nil {
+		return v.h64.NumberOfRvaAndSizes
+	}
+
+	return v.h32.NumberOfRvaAndSizes
+}
+
+// index超过NumberOfRvaAndSizes时返回空的目录.
+func (v *OptionHeaderView) DataDirectory(index int) pe.DataDirectory {
+	if index < 0 || index >= int(v.NumberOfRvaAndSizes()) || index >= 16 {
+		return pe.DataDirectory{}
+	}
+
+	if v.h64 != nil {
+		return v.h64.DataDirectory[index]
+	}
+
+	return v.h32.DataDirectory[index]
+}
+
+// 需要时同时增加NumberOfRvaAndSizes和SizeOfOptionalHeader.
+func (v *OptionHeaderView) SetDataDirectory(index int, d pe.DataDirectory) error {
+	if index < 0 || index >= 16 {
+		return ErrOutOfRange
+	}
+
+	if index != pe.IMAGE_DIRECTORY_ENTRY_SECURITY && d.Size > 0 && //安全目录记录的是文件偏移
+		uint64(d.VirtualAddress)+uint64(d.Size) > uint64(v.SizeOfImage()) {
+		return ErrOutOfRange
+	}
+
+	count := v.NumberOfRvaAndSizes()
+	if uint32(index) >= count {
+		if d.VirtualAddress == 0 && d.Size == 0 {
+			return nil
+		}
+
+		grow := uint32(index) + 1 - count
+		v.p.File.SizeOfOptionalHeader += uint16(grow * 8)
+		count += grow
+	}
+
+	if v.h64 != nil {
+		v.h64.NumberOfRvaAndSizes = count
+		v.h64.DataDirectory[index] = d
+	} else {
+		v.h32.NumberOfRvaAndSizes = count
+		v.h32.DataDirectory[index] = d
+	}
+
+	return nil
+}
+
+func (v *OptionHeaderView) SectionAlignment() uint32 {
+	if v.h64 != nil {
+		return v.h64.SectionAlignment
+	}
+
+	return v.h32.SectionAlignment
+}
+
+func (v *OptionHeaderView) FileAlignment() uint32 {
+	if v.h64 != nil {
+		return v.h64.FileAlignment
+	}
+
+	return v.h32.FileAlignment
+}
+
+func (v *OptionHeaderView) setFileAlignment(align uint32) {
+	if v.h64 != nil {
+		v.h64.FileAlignment = align
+	} else {
+		v.h32.FileAlignment = align
+	}
+}
+
+func (v *OptionHeaderView) SizeOfImage() uint32 {
+	if v.h64 != nil {
+		return v.h64.SizeOfImage
+	}
+
+	return v.h32.SizeOfImage
+}
+
+func (v *OptionHeaderView) SizeOfHeaders() uint32 {
+	if v.h64 != nil {
+		return v.h64.SizeOfHeaders
+	}
+
+	return v.h32.SizeOfHeaders
+}
+
+func (v *OptionHeaderView) setSizeOfHeaders(size uint32) {
+	if v.h64 != nil {
+		v.h64.SizeOfHeaders = size
+	} else {
+		v.h32.SizeOfHeaders = size
+	}
+}
+
+func (v *OptionHeaderView) CheckSum() uint32 {
+	if v.h64 != nil {
+		return v.h64.CheckSum
+	}
+
+	return v.h32.CheckSum
+}
+
+func (v *OptionHeaderView) setCheckSum(sum uint32) {
+	if v.h64 != nil {
+		v.h64.CheckSum = sum
+	} else {
+		v.h32.CheckSum = sum
+	}
+}
+
+// 节变化之后重新计算的字段.
+func (v *OptionHeaderView) setSizes(code, data, bss, image uint32) {
+	if v.h64 != nil {
+		h := v.h64
+		h.SizeOfCode, h.SizeOfInitializedData, h.SizeOfUninitializedData, h.SizeOfImage = code, data, bss, image
+	} else {
+		h := v.h32
+		h.SizeOfCode, h.SizeOfInitializedData, h.SizeOfUninitializedData, h.SizeOfImage = code, data, bss, image
+	}
+}
@@ -0,0 +1,75 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"testing"
+)
+
+func TestOptionHeaderView(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	h := f.OptionHeaderView()
+	if h == nil || h.Is64() || h.Magic() != ImageOptionHeaderMagicHdr32 {
+		t.Fatalf("OptionHeaderView error")
+	}
+
+	if err = h.SetImageBase(0x100000000); err != ErrOutOfRange {
+		t.Fatalf("SetImageBase should fail for PE32, get: %v", err)
+	}
+
+	if err = h.SetImageBase(0x12345); err != ErrOutOfRange {
+		t.Fatalf("SetImageBase should require 64K alignment, get: %v", err)
+	}
+
+	if err = h.SetStackSize(0x1000, 0x2000); err != ErrOutOfRange {
+		t.Fatalf("SetStackSize should fail, get: %v", err)
+	}
+
+	if err = h.SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_EXPORT, pe.DataDirectory{VirtualAddress: 0x1d000, Size: 0x2000}); err != ErrOutOfRange {
+		t.Fatalf("SetDataDirectory should fail, get: %v", err)
+	}
+
+	entry := h.AddressOfEntryPoint() + 0x10
+	dir := pe.DataDirectory{VirtualAddress: 0x1b000, Size: 0x10}
+	checks := []error{
+		h.SetImageBase(0x10000000),
+		h.SetAddressOfEntryPoint(entry),
+		h.SetStackSize(0x200000, 0x2000),
+		h.SetHeapSize(0x200000, 0x2000),
+		h.SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_EXPORT, dir),
+	}
+
+	for i, err := range checks {
+		if err != nil {
+			t.Fatalf("setter %v failed: %v", i, err)
+		}
+	}
+
+	h.SetSubsystem(IMAGE_SUBSYSTEM_WINDOWS_GUI)
+	h.SetDllCharacteristics(h.DllCharacteristics() &^ IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE)
+	h.SetOperatingSystemVersion(6, 1)
+	h.SetSubsystemVersion(6, 2)
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	n, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	o := n.OptionHeader()
+	if o.ImageBase != 0x10000000 || o.AddressOfEntryPoint != entry || o.SizeOfStackReserve != 0x200000 ||
+		o.SizeOfHeapCommit != 0x2000 || o.Subsystem != IMAGE_SUBSYSTEM_WINDOWS_GUI ||
+		o.DllCharacteristics&IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE != 0 || o.MajorOperatingSystemVersion != 6 ||
+		o.MinorSubsystemVersion != 2 || o.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_EXPORT] != dir {
+		t.Fatalf("option header not written: %+v", o)
+	}
+}
@@ -0,0 +1,338 @@
+package pefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrInvalidIcon = errors.New("pefile: invalid icon")
+
+const (
+	iconDirSize         = 6
+	iconDirEntrySize    = 16 //.ico/.cur文件中的项
+	groupIconEntrySize  = 14 //RT_GROUP_ICON/RT_GROUP_CURSOR中的项
+	iconTypeIcon        = 1
+	iconTypeCursor      = 2
+	cursorHotspotSize   = 4
+	bitmapInfoHeaderMin = 16
+)
+
+// 图标或光标中的一个图像, Data是BMP(没有文件头)或PNG数据.
+type IconImage struct {
+	Width, Height      uint16 //像素, 最大256
+	ColorCount         uint8
+	Planes, BitCount   uint16
+	HotspotX, HotspotY uint16 //只用于光标
+	Data               []byte
+}
+
+type IconGroup struct {
+	Cursor bool
+	Images []*IconImage
+}
+
+// 解析.ico或.cur文件.
+func ParseIcon(data []byte) (*IconGroup, error) {
+	if len(data) < iconDirSize || binary.LittleEndian.Uint16(data) != 0 {
+		return nil, ErrInvalidIcon
+	}
+
+	typ, count := binary.LittleEndian.Uint16(data[2:]), int(binary.LittleEndian.Uint16(data[4:]))
+	if typ != iconTypeIcon && typ != iconTypeCursor || iconDirSize+count*iconDirEntrySize > len(data) {
+		return nil, ErrInvalidIcon
+	}
+
+	ret := &IconGroup{Cursor: typ == iconTypeCursor}
+	for i := 0; i < count; i++ {
+		e := data[iconDirSize+i*iconDirEntrySize:]
+		size, offset := binary.LittleEndian.Uint32(e[8:]), binary.LittleEndian.Uint32(e[12:])
+		if uint64(offset)+uint64(size) > uint64(len(data)) {
+			return nil, ErrInvalidIcon
+		}
+
+		img := &IconImage{Width: iconSize(e[0]), Height: iconSize(e[1]), ColorCount: e[2],
+			Data: append([]byte{}, data[offset:offset+size]...)}
+		if ret.Cursor {
+			img.HotspotX, img.HotspotY = binary.LittleEndian.Uint16(e[4:]), binary.LittleEndian.Uint16(e[6:])
+			img.Planes, img.BitCount = imageFormat(img.Data)
+		} else {
+			img.Planes, img.BitCount = binary.LittleEndian.Uint16(e[4:]), binary.LittleEndian.Uint16(e[6:])
+		}
+		ret.Images = append(ret.Images, img)
+	}
+
+	return ret, nil
+}
+
+// 文件中0表示256.
+func iconSize(b uint8) uint16 {
+	if b == 0 {
+		return 256
+	}
+
+	return uint16(b)
+}
+
+// 从BITMAPINFOHEADER中读取位面数和颜色位数, PNG按32位处理.
+func imageFormat(data []byte) (planes, bitCount uint16) {
+	if bytes.HasPrefix(data, []byte("\x89PNG")) {
+		return 1, 32
+	}
+
+	if len(data) < bitmapInfoHeaderMin {
+		return 0, 0
+	}
+
+	return binary.LittleEndian.Uint16(data[12:]), binary.LittleEndian.Uint16(data[14:])
+}
+
+// 生成.ico或.cur文件.
+func (g *IconGroup) Bytes() []byte {
+	typ := uint16(iconTypeIcon)
+	if g.Cursor {
+		typ = iconTypeCursor
+	}
+
+	ret := make([]byte, iconDirSize+len(g.Images)*iconDirEntrySize)
+	binary.LittleEndian.PutUint16(ret[2:], typ)
+	binary.LittleEndian.PutUint16(ret[4:], uint16(len(g.Images)))
+	for i, img := range g.Images {
+		e := ret[iconDirSize+i*iconDirEntrySize:]
+		e[0], e[1], e[2] = uint8(img.Width), uint8(img.Height), img.ColorCount
+		if g.Cursor {
+			binary.LittleEndian.PutUint16(e[4:], img.HotspotX)
+			binary.LittleEndian.PutUint16(e[6:], img.HotspotY)
+		} else {
+			binary.LittleEndian.PutUint16(e[4:], img.Planes)
+			binary.LittleEndian.PutUint16(e[6:], img.BitCount)
+		}
+		binary.LittleEndian.PutUint32(e[8:], uint32(len(img.Data)))
+		binary.LittleEndian.PutUint32(e[12:], uint32(len(ret)))
+		ret = append(ret, img.Data...)
+	}
+
+	return ret
+}
+
+func iconResourceTypes(cursor bool) (group, image ResourceID) {
+	if cursor {
+		return ResID(RT_GROUP_CURSOR), ResID(RT_CURSOR)
+	}
+
+	return ResID(RT_GROUP_ICON), ResID(RT_ICON)
+}
+
+// 返回全部图标组的名字, 第一个通常是程序的图标.
+func (p *PeFile) IconGroups() ([]ResourceID, error) {
+	return p.iconGroups(false)
+}
+
+func (p *PeFile) CursorGroups() ([]ResourceID, error) {
+	return p.iconGroups(true)
+}
+
+func (p *PeFile) iconGroups(cursor bool) ([]ResourceID, error) {
+	root, err := p.Resources()
+	if root == nil || err != nil {
+		return nil, err
+	}
+
+	group, _ := iconResourceTypes(cursor)
+	var ret []ResourceID
+	if e := root.Entry(group); e != nil && e.Directory != nil {
+		for _, e := range sortResourceEntries(e.Directory.Entries) {
+			ret = append(ret, e.ResourceID)
+		}
+	}
+
+	return ret, nil
+}
+
+// 读取图标组和其中的图标, 没有时返回nil, nil.
+func (p *PeFile) Icon(name ResourceID) (*IconGroup, error) {
+	return p.iconGroup(name, false)
+}
+
+func (p *PeFile) Cursor(name ResourceID) (*IconGroup, error) {
+	return p.iconGroup(name, true)
+}
+
+func (p *PeFile) iconGroup(name ResourceID, cursor bool) (*IconGroup, error) {
+	root, err := p.Resources()
+	if root == nil || err != nil {
+		return nil, err
+	}
+
+	groupType, imageType := iconResourceTypes(cursor)
+	list := root.Find(groupType, name)
+	if len(list) == 0 {
+		return nil, nil
+	}
+
+	data, err := list[0].Data.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < iconDirSize || binary.LittleEndian.Uint16(data) != 0 {
+		return nil, ErrInvalidIcon
+	}
+
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	if iconDirSize+count*groupIconEntrySize > len(data) {
+		return nil, ErrInvalidIcon
+	}
+
+	ret := &IconGroup{Cursor: cursor}
+	for i := 0; i < count; i++ {
+		e := data[iconDirSize+i*groupIconEntrySize:]
+		id := ResID(uint32(binary.LittleEndian.Uint16(e[12:])))
+		images := root.Find(imageType, id)
+		if len(images) == 0 {
+			return nil, ErrInvalidIcon
+		}
+
+		//优先使用和组相同的语言
+		image := images[0]
+		for _, r := range images {
+			if r.Lang == list[0].Lang {
+				image = r
+			}
+		}
+
+		b, err := image.Data.Bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		img := &IconImage{Planes: binary.LittleEndian.Uint16(e[4:]), BitCount: binary.LittleEndian.Uint16(e[6:])}
+		if cursor {
+			if len(b) < cursorHotspotSize {
+				return nil, ErrInvalidIcon
+			}
+
+			//光标的高度包括AND掩码
+			img.Width, img.Height = binary.LittleEndian.Uint16(e[0:]), binary.LittleEndian.Uint16(e[2:])/2
+			img.HotspotX, img.HotspotY = binary.LittleEndian.Uint16(b[0:]), binary.LittleEndian.Uint16(b[2:])
+			b = b[cursorHotspotSize:]
+		} else {
+			img.Width, img.Height, img.ColorCount = iconSize(e[0]), iconSize(e[1]), e[2]
+		}
+		img.Data = append([]byte{}, b...)
+		ret.Images = append(ret.Images, img)
+	}
+
+	return ret, nil
+}
+
+// 替换或添加图标组并重新生成资源节, 原来组中的图标被删除.
+func (p *PeFile) SetIcon(name ResourceID, g *IconGroup) error {
+	return p.setIconGroup(name, g, false)
+}
+
+func (p *PeFile) SetCursor(name ResourceID, g *IconGroup) error {
+	return p.setIconGroup(name, g, true)
+}
+
+func (p *PeFile) setIconGroup(name ResourceID, g *IconGroup, cursor bool) error {
+	root, err := p.Resources()
+	if err != nil {
+		return err
+	} else if root == nil {
+		root = &ResourceDirectory{}
+	}
+
+	groupType, imageType := iconResourceTypes(cursor)
+	lang := uint16(0)
+	if list := root.Find(groupType, name); len(list) > 0 {
+		lang = list[0].Lang
+		old := make(map[uint16]bool) //全部语言的组使用的图像
+		for _, r := range list {
+			ids, _ := iconGroupIDs(r.Data)
+			for _, id := range ids {
+				old[id] = true
+			}
+		}
+		root.DeleteAll(groupType, name)
+
+		//其它组还在使用的图像保留
+		for _, r := range root.List() {
+			if r.Type == groupType {
+				ids, _ := iconGroupIDs(r.Data)
+				for _, id := range ids {
+					delete(old, id)
+				}
+			}
+		}
+
+		for id := range old {
+			root.DeleteAll(imageType, ResID(uint32(id)))
+		}
+	}
+
+	//新图标使用现有最大的ID之后的ID
+	next := uint32(1)
+	if e := root.Entry(imageType); e != nil && e.Directory != nil {
+		for _, e := range e.Directory.Entries {
+			if !e.IsNamed() && e.ID >= next {
+				next = e.ID + 1
+			}
+		}
+	}
+
+	group := make([]byte, iconDirSize+len(g.Images)*groupIconEntrySize)
+	binary.LittleEndian.PutUint16(group[2:], iconTypeIcon)
+	if cursor {
+		binary.LittleEndian.PutUint16(group[2:], iconTypeCursor)
+	}
+	binary.LittleEndian.PutUint16(group[4:], uint16(len(g.Images)))
+
+	for i, img := range g.Images {
+		if next > 0xffff {
+			return ErrInvalidIcon
+		}
+
+		e := group[iconDirSize+i*groupIconEntrySize:]
+		data := img.Data
+		if cursor {
+			binary.LittleEndian.PutUint16(e[0:], img.Width)
+			binary.LittleEndian.PutUint16(e[2:], img.Height*2)
+			data = make([]byte, cursorHotspotSize, cursorHotspotSize+len(img.Data))
+			binary.LittleEndian.PutUint16(data[0:], img.HotspotX)
+			binary.LittleEndian.PutUint16(data[2:], img.HotspotY)
+			data = append(data, img.Data...)
+		} else {
+			e[0], e[1], e[2] = uint8(img.Width), uint8(img.Height), img.ColorCount
+		}
+		binary.LittleEndian.PutUint16(e[4:], img.Planes)
+		binary.LittleEndian.PutUint16(e[6:], img.BitCount)
+		binary.LittleEndian.PutUint32(e[8:], uint32(len(data)))
+		binary.LittleEndian.PutUint16(e[12:], uint16(next))
+
+		root.Set(imageType, ResID(next), lang, data)
+		next++
+	}
+
+	root.Set(groupType, name, lang, group)
+	return p.SetResources(root)
+}
+
+func iconGroupIDs(d *ResourceData) ([]uint16, error) {
+	data, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	if len(data) < iconDirSize {
+		return nil, ErrInvalidIcon
+	}
+
+	var ret []uint16
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	for i := 0; i < count && iconDirSize+(i+1)*groupIconEntrySize <= len(data); i++ {
+		ret = append(ret, binary.LittleEndian.Uint16(data[iconDirSize+i*groupIconEntrySize+12:]))
+	}
+
+	return ret, nil
+}
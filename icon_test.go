@@ -0,0 +1,151 @@
+package pefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"testing"
+)
+
+// 生成一个没有实际像素数据的BMP图像.
+func testIconImage(width, height uint16, bitCount uint16) []byte {
+	data := make([]byte, 40+int(width)*int(height)*int(bitCount)/8)
+	binary.LittleEndian.PutUint32(data[0:], 40)
+	binary.LittleEndian.PutUint32(data[4:], uint32(width))
+	binary.LittleEndian.PutUint32(data[8:], uint32(height)*2)
+	binary.LittleEndian.PutUint16(data[12:], 1)
+	binary.LittleEndian.PutUint16(data[14:], bitCount)
+	for i := 40; i < len(data); i++ {
+		data[i] = byte(i)
+	}
+
+	return data
+}
+
+func TestIcon(t *testing.T) {
+	icon := &IconGroup{Images: []*IconImage{
+		{Width: 16, Height: 16, Planes: 1, BitCount: 32, Data: testIconImage(16, 16, 32)},
+		{Width: 32, Height: 32, Planes: 1, BitCount: 8, Data: testIconImage(32, 32, 8)},
+		{Width: 256, Height: 256, Planes: 1, BitCount: 32, Data: []byte("\x89PNG\r\n\x1a\n")},
+	}}
+
+	ico := icon.Bytes()
+	g, err := ParseIcon(ico)
+	if err != nil {
+		t.Fatalf("ParseIcon failed: %v", err)
+	}
+
+	if bytes.Compare(g.Bytes(), ico) != 0 || g.Images[2].Width != 256 {
+		t.Fatalf("ico round trip failed")
+	}
+
+	if _, err = ParseIcon(ico[:len(ico)-1]); err == nil {
+		t.Fatalf("ParseIcon should fail with truncated data")
+	}
+
+	cursor := &IconGroup{Cursor: true, Images: []*IconImage{
+		{Width: 32, Height: 32, HotspotX: 3, HotspotY: 5, Data: testIconImage(32, 32, 1)},
+	}}
+	cur := cursor.Bytes()
+	if g, err = ParseIcon(cur); err != nil || !g.Cursor || g.Images[0].Planes != 1 || g.Images[0].BitCount != 1 || g.Images[0].HotspotY != 5 {
+		t.Fatalf("ParseIcon cursor failed: %v", err)
+	}
+
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if names, err := f.IconGroups(); len(names) != 0 || err != nil {
+		t.Fatalf("hello_gcc_exe should not have icons")
+	}
+
+	if err = f.SetIcon(ResID(1), icon); err != nil {
+		t.Fatalf("SetIcon failed: %v", err)
+	}
+
+	if err = f.SetCursor(ResName("ARROW"), g); err != nil {
+		t.Fatalf("SetCursor failed: %v", err)
+	}
+
+	//替换后旧的图标被删除
+	icon.Images = icon.Images[1:]
+	if err = f.SetIcon(ResID(1), icon); err != nil {
+		t.Fatalf("SetIcon failed: %v", err)
+	}
+	ico = icon.Bytes()
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	n, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	names, err := n.IconGroups()
+	if err != nil || len(names) != 1 || names[0] != ResID(1) {
+		t.Fatalf("IconGroups failed: %v %v", names, err)
+	}
+
+	if g, err = n.Icon(names[0]); err != nil || g == nil || bytes.Compare(g.Bytes(), ico) != 0 {
+		t.Fatalf("Icon failed: %v", err)
+	}
+
+	if g, err = n.Cursor(ResName("ARROW")); err != nil || g == nil || bytes.Compare(g.Bytes(), cur) != 0 {
+		t.Fatalf("Cursor failed: %v", err)
+	}
+
+	root, _ := n.Resources()
+	if len(root.Entry(ResID(RT_ICON)).Directory.Entries) != 2 || root.Get(ResID(RT_ICON), ResID(2)) == nil ||
+		root.Get(ResID(RT_CURSOR), ResID(1)) == nil || root.Get(ResID(RT_MANIFEST), ResID(1), ResID(0)) == nil {
+		t.Fatalf("resources error")
+	}
+}
+
+func TestIconShared(t *testing.T) {
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	icon := &IconGroup{Images: []*IconImage{
+		{Width: 16, Height: 16, Planes: 1, BitCount: 32, Data: testIconImage(16, 16, 32)},
+		{Width: 32, Height: 32, Planes: 1, BitCount: 8, Data: testIconImage(32, 32, 8)},
+	}}
+	if err = f.SetIcon(ResName("A"), icon); err != nil {
+		t.Fatalf("SetIcon failed: %v", err)
+	}
+
+	//B和A共用图像1和2, A的0x804语言只使用图像4
+	root, _ := f.Resources()
+	group, _ := root.Get(ResID(RT_GROUP_ICON), ResName("A"), ResID(0)).Data.Bytes()
+	root.Set(ResID(RT_GROUP_ICON), ResName("B"), 0, group)
+
+	other := append([]byte{}, group[:iconDirSize+groupIconEntrySize]...)
+	binary.LittleEndian.PutUint16(other[4:], 1)
+	binary.LittleEndian.PutUint16(other[iconDirSize+12:], 4)
+	root.Set(ResID(RT_GROUP_ICON), ResName("A"), 0x804, other)
+	root.Set(ResID(RT_ICON), ResID(4), 0x804, icon.Images[0].Data)
+	if err = f.SetResources(root); err != nil {
+		t.Fatalf("SetResources failed: %v", err)
+	}
+
+	icon.Images = icon.Images[1:]
+	if err = f.SetIcon(ResName("A"), icon); err != nil {
+		t.Fatalf("SetIcon failed: %v", err)
+	}
+
+	root, _ = f.Resources()
+	if root.Get(ResID(RT_ICON), ResID(1)) == nil || root.Get(ResID(RT_ICON), ResID(2)) == nil || root.Get(ResID(RT_ICON), ResID(4)) != nil ||
+		len(root.Entry(ResID(RT_ICON)).Directory.Entries) != 3 || len(root.Get(ResID(RT_GROUP_ICON), ResName("A")).Directory.Entries) != 1 {
+		t.Fatalf("icon images error: %+v", root.List())
+	}
+
+	if g, err := f.Icon(ResName("B")); err != nil || g == nil || len(g.Images) != 2 {
+		t.Fatalf("shared icon group broken: %v", err)
+	}
+}
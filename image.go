@@ -0,0 +1,167 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"errors"
+	"io"
+)
+
+var ErrInvalidRVA = errors.New("pefile: invalid rva")
+
+const maxStringSize = 0x10000
+
+// 按rva读取映像数据, 包括新加的节. 节中超过文件数据部分的内容为0.
+func (p *PeFile) ReadRVA(rva, size uint32) ([]byte, error) {
+	buf := make([]byte, size)
+	if _, err := p.readAtRVA(buf, rva); err != nil {
+		return nil, err
+	}
+
+	return buf, nil
+}
+
+func (p *PeFile) readAtRVA(b []byte, rva uint32) (n int, err error) {
+	end := uint64(rva) + uint64(len(b))
+
+	for _, s := range p.File.Sections {
+		if rva >= s.VirtualAddress && end <= uint64(s.VirtualAddress)+uint64(virtualSize(s)) {
+			off := rva - s.VirtualAddress
+			for i := range b {
+				b[i] = 0
+			}
+
+			if data, ok := p.sectionData[s]; ok {
+				if off < uint32(len(data)) {
+					copy(b, data[off:])
+				}
+			} else if off < s.Size {
+				size := uint32(len(b))
+				if s.Size-off < size {
+					size = s.Size - off
+				}
+
+				if _, err = s.ReadAt(b[:size], int64(off)); err != nil && err != io.EOF {
+					return 0, err
+				}
+			}
+
+			return len(b), nil
+		}
+	}
+
+	for _, s := range p.mySections {
+		if rva >= s.virtualAddress && end <= uint64(s.virtualAddress)+uint64(s.virtualSize) {
+			off := rva - s.virtualAddress
+			for i := range b {
+				b[i] = 0
+			}
+			copy(b, s.data[off:])
+
+			return len(b), nil
+		}
+	}
+
+	return 0, ErrInvalidRVA
+}
+
+// 按rva修改映像数据, 只能修改节中有文件数据的部分. 新加的节直接修改AddSection传入的数据.
+func (p *PeFile) WriteRVA(rva uint32, b []byte) error {
+	end := uint64(rva) + uint64(len(b))
+
+	for _, s := range p.File.Sections {
+		if rva >= s.VirtualAddress && end <= uint64(s.VirtualAddress)+uint64(virtualSize(s)) {
+			data, err := p.editSection(s)
+			if err != nil {
+				return err
+			}
+
+			off := rva - s.VirtualAddress
+			if end-uint64(s.VirtualAddress) > uint64(len(data)) {
+				return ErrInvalidRVA
+			}
+
+			copy(data[off:], b)
+			return nil
+		}
+	}
+
+	for _, s := range p.mySections {
+		if rva >= s.virtualAddress && end <= uint64(s.virtualAddress)+uint64(len(s.data)) {
+			copy(s.data[rva-s.virtualAddress:], b)
+			return nil
+		}
+	}
+
+	return ErrInvalidRVA
+}
+
+// 目录从节的开始处开始并且覆盖整个节时返回true, 这样的节中只有目录的数据, 可以整体替换或删除.
+func (p *PeFile) isDirectorySection(d pe.DataDirectory) bool {
+	if d.VirtualAddress == 0 {
+		return false
+	}
+
+	for _, s := range p.File.Sections {
+		if s.VirtualAddress == d.VirtualAddress {
+			return d.Size >= virtualSize(s)
+		}
+	}
+
+	for _, s := range p.mySections {
+		if s.virtualAddress == d.VirtualAddress {
+			return d.Size >= s.virtualSize
+		}
+	}
+
+	return false
+}
+
+// 返回可以修改的节数据, 修改之后WriteTo写出修改的数据.
+func (p *PeFile) editSection(s *pe.Section) ([]byte, error) {
+	if data, ok := p.sectionData[s]; ok {
+		return data, nil
+	}
+
+	data, err := s.Data()
+	if err != nil {
+		return nil, err
+	}
+
+	if p.sectionData == nil {
+		p.sectionData = make(map[*pe.Section][]byte)
+	}
+	p.sectionData[s] = data
+
+	return data, nil
+}
+
+// 读取rva处以0结尾的字符串.
+func (p *PeFile) readStringRVA(rva uint32) (string, error) {
+	var buf [64]byte
+	var ret []byte
+
+	for len(ret) < maxStringSize {
+		b := buf[:]
+		if _, err := p.readAtRVA(b, rva); err != nil {
+			for b = b[:len(b)/2]; len(b) > 0; b = b[:len(b)/2] { //可能在节的结尾
+				if _, err = p.readAtRVA(b, rva); err == nil {
+					break
+				}
+			}
+
+			if len(b) == 0 {
+				return "", err
+			}
+		}
+
+		if i := bytes.IndexByte(b, 0); i >= 0 {
+			return string(append(ret, b[:i]...)), nil
+		}
+
+		ret = append(ret, b...)
+		rva += uint32(len(b))
+	}
+
+	return "", ErrInvalidRVA
+}
@@ -0,0 +1,128 @@
+package pefile
+
+import (
+	"debug/pe"
+	"encoding/binary"
+)
+
+const (
+	imageImportDescriptorSize = 20
+	maxImportThunks           = 0x10000
+)
+
+type ImportedFunction struct {
+	ThunkRVA  uint32 //IAT中的rva
+	Thunk     uint64 //INT中的值, 没有INT时为IAT中的值
+	IAT       uint64 //IAT中的值, 绑定之后是函数地址
+	ByOrdinal bool
+	Ordinal   uint16
+	Hint      uint16
+	Name      string
+}
+
+type ImportedLibrary struct {
+	Descriptor ImageImportDescriptor
+	Name       string
+	Functions  []ImportedFunction
+}
+
+func (p *PeFile) Imports() ([]ImportedLibrary, error) {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return nil, nil
+	}
+
+	dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_IMPORT)
+	if dir.VirtualAddress == 0 {
+		return nil, nil
+	}
+
+	var ret []ImportedLibrary
+	for rva := dir.VirtualAddress; ; rva += imageImportDescriptorSize {
+		data, err := p.ReadRVA(rva, imageImportDescriptorSize)
+		if err != nil {
+			return ret, err
+		}
+
+		var d ImageImportDescriptor
+		d.OriginalFirstThunk = binary.LittleEndian.Uint32(data[0:])
+		d.TimeDateStamp = binary.LittleEndian.Uint32(data[4:])
+		d.ForwarderChain = binary.LittleEndian.Uint32(data[8:])
+		d.Name = binary.LittleEndian.Uint32(data[12:])
+		d.FirstThunk = binary.LittleEndian.Uint32(data[16:])
+		if d == (ImageImportDescriptor{}) {
+			break
+		}
+
+		lib := ImportedLibrary{Descriptor: d}
+		if lib.Name, err = p.readStringRVA(d.Name); err != nil {
+			return ret, err
+		}
+
+		if lib.Functions, err = p.readThunks(d, h.Is64()); err != nil {
+			return ret, err
+		}
+
+		ret = append(ret, lib)
+	}
+
+	return ret, nil
+}
+
+func (p *PeFile) readThunks(d ImageImportDescriptor, is64 bool) (ret []ImportedFunction, err error) {
+	size := uint32(4)
+	ordinalFlag := uint64(1) << 31
+	if is64 {
+		size = 8
+		ordinalFlag = 1 << 63
+	}
+
+	lookup := d.OriginalFirstThunk
+	if lookup == 0 {
+		lookup = d.FirstThunk
+	}
+
+	read := func(rva uint32) (uint64, error) {
+		data, err := p.ReadRVA(rva, size)
+		if err != nil {
+			return 0, err
+		}
+
+		if is64 {
+			return binary.LittleEndian.Uint64(data), nil
+		}
+		return uint64(binary.LittleEndian.Uint32(data)), nil
+	}
+
+	for i := uint32(0); i < maxImportThunks; i++ {
+		var f ImportedFunction
+		if f.Thunk, err = read(lookup + i*size); err != nil || f.Thunk == 0 {
+			break
+		}
+
+		f.ThunkRVA = d.FirstThunk + i*size
+		if f.IAT, err = read(f.ThunkRVA); err != nil {
+			break
+		}
+
+		if f.Thunk&ordinalFlag != 0 {
+			f.ByOrdinal = true
+			f.Ordinal = uint16(f.Thunk)
+		} else {
+			rva := uint32(f.Thunk & 0x7fffffff)
+			var hint []byte
+			if hint, err = p.ReadRVA(rva, 2); err != nil {
+				break
+			}
+
+			f.Hint = binary.LittleEndian.Uint16(hint)
+			if f.Name, err = p.readStringRVA(rva + 2); err != nil {
+				break
+			}
+		}
+
+		ret = append(ret, f)
+	}
+
+	return
+}
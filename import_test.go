@@ -0,0 +1,51 @@
+package pefile
+
+import (
+	"sort"
+	"strings"
+	"testing"
+)
+
+func TestImports(t *testing.T) {
+	for _, name := range []string{"hello_gcc_exe", "hello_vc_exe"} {
+		f, err := Open("testdata/" + name)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer f.Close()
+
+		libs, err := f.Imports()
+		if err != nil || len(libs) == 0 {
+			t.Fatalf("%v Imports failed: %v", name, err)
+		}
+
+		size := uint32(4)
+		if f.IsOptionHeader64() {
+			size = 8
+		}
+
+		var get []string
+		for _, lib := range libs {
+			for i, fn := range lib.Functions {
+				if fn.ThunkRVA != lib.Descriptor.FirstThunk+uint32(i)*size {
+					t.Fatalf("%v thunk rva error", name)
+				}
+
+				if !fn.ByOrdinal {
+					get = append(get, fn.Name+":"+lib.Name)
+				}
+			}
+		}
+
+		should, err := f.File.ImportedSymbols()
+		if err != nil {
+			t.Fatalf("ImportedSymbols failed: %v", err)
+		}
+
+		sort.Strings(get)
+		sort.Strings(should)
+		if strings.Join(get, ",") != strings.Join(should, ",") {
+			t.Fatalf("%v imports should: %v, get: %v", name, should, get)
+		}
+	}
+}
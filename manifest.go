@@ -0,0 +1,316 @@
+package pefile
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+)
+
+var ErrInvalidManifest = errors.New("pefile: invalid manifest")
+
+const (
+	CREATEPROCESS_MANIFEST_RESOURCE_ID  = 1
+	ISOLATIONAWARE_MANIFEST_RESOURCE_ID = 2
+)
+
+type ManifestAssembly struct {
+	Type                  string
+	Name                  string
+	Version               string
+	ProcessorArchitecture string
+	PublicKeyToken        string
+	Language              string
+}
+
+// 清单的摘要, 只包含常用的字段.
+type Manifest struct {
+	Identity       *ManifestAssembly
+	ExecutionLevel string //asInvoker, highestAvailable, requireAdministrator
+	UIAccess       bool
+	DPIAware       string
+	DPIAwareness   string
+	SupportedOS    []string
+	Dependencies   []ManifestAssembly
+}
+
+func ParseManifest(data []byte) (*Manifest, error) {
+	d := xml.NewDecoder(bytes.NewReader(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))))
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil //按UTF-8处理
+	}
+
+	ret := &Manifest{}
+	var stack []string
+	var text strings.Builder
+	for {
+		t, err := d.Token()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %v", ErrInvalidManifest, err)
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			parent := ""
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			stack = append(stack, t.Name.Local)
+			text.Reset()
+
+			switch t.Name.Local {
+			case "requestedExecutionLevel":
+				ret.ExecutionLevel = manifestAttr(t, "level")
+				ret.UIAccess = strings.EqualFold(manifestAttr(t, "uiAccess"), "true")
+			case "supportedOS":
+				ret.SupportedOS = append(ret.SupportedOS, manifestAttr(t, "Id"))
+			case "assemblyIdentity":
+				a := ManifestAssembly{manifestAttr(t, "type"), manifestAttr(t, "name"), manifestAttr(t, "version"),
+					manifestAttr(t, "processorArchitecture"), manifestAttr(t, "publicKeyToken"), manifestAttr(t, "language")}
+				if parent == "dependentAssembly" {
+					ret.Dependencies = append(ret.Dependencies, a)
+				} else if parent == "assembly" && len(stack) == 2 {
+					ret.Identity = &a
+				}
+			}
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			switch t.Name.Local {
+			case "dpiAware":
+				ret.DPIAware = strings.TrimSpace(text.String())
+			case "dpiAwareness":
+				ret.DPIAwareness = strings.TrimSpace(text.String())
+			}
+			stack = stack[:len(stack)-1]
+		}
+	}
+
+	if len(stack) != 0 {
+		return nil, ErrInvalidManifest
+	}
+
+	return ret, nil
+}
+
+func manifestAttr(e xml.StartElement, name string) string {
+	for _, a := range e.Attr {
+		if a.Name.Local == name {
+			return a.Value
+		}
+	}
+
+	return ""
+}
+
+var (
+	manifestExecutionLevels = []string{"asInvoker", "highestAvailable", "requireAdministrator"}
+	manifestTrustPath       = []string{"assembly", "trustInfo", "security", "requestedPrivileges"} //requestedExecutionLevel的上级
+)
+
+type manifestElement struct {
+	name       xml.Name //Space是原始的前缀
+	start, end int      //开始标记的位置
+}
+
+// 修改第一个requestedExecutionLevel(不包括注释中的), 其它内容保持不变.
+// 没有时添加到已有的trustInfo中, 缺少的上级元素一起添加.
+func SetManifestExecutionLevel(data []byte, level string, uiAccess bool) ([]byte, error) {
+	if _, err := ParseManifest(data); err != nil {
+		return nil, err
+	}
+
+	valid := false
+	for _, v := range manifestExecutionLevels {
+		valid = valid || v == level
+	}
+
+	if !valid {
+		return nil, fmt.Errorf("%v: execution level %q", ErrInvalidManifest, level)
+	}
+
+	ui := "false"
+	if uiAccess {
+		ui = "true"
+	}
+
+	bom := len(data) - len(bytes.TrimPrefix(data, []byte("\xef\xbb\xbf")))
+	d := xml.NewDecoder(bytes.NewReader(data[bom:]))
+	d.CharsetReader = func(charset string, input io.Reader) (io.Reader, error) {
+		return input, nil
+	}
+
+	var stack []manifestElement
+	var parent *manifestElement //manifestTrustPath中已有的最深的元素
+	depth, pos := 0, 0          //parent的深度和结束标记的位置
+	for {
+		offset := bom + int(d.InputOffset())
+		t, err := d.RawToken()
+		if err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, fmt.Errorf("%v: %v", ErrInvalidManifest, err)
+		}
+
+		switch t := t.(type) {
+		case xml.StartElement:
+			e := manifestElement{t.Name, offset, bom + int(d.InputOffset())}
+			if t.Name.Local == "requestedExecutionLevel" {
+				closing := ">"
+				if bytes.HasSuffix(data[e.start:e.end], []byte("/>")) {
+					closing = "/>"
+				}
+
+				tag := fmt.Sprintf(`<%vrequestedExecutionLevel level="%v" uiAccess="%v"%v`, manifestPrefix(t.Name), level, ui, closing)
+				return append(append(append([]byte{}, data[:e.start]...), tag...), data[e.end:]...), nil
+			}
+			stack = append(stack, e)
+		case xml.EndElement:
+			if len(stack) == 0 {
+				return nil, ErrInvalidManifest
+			}
+
+			e := stack[len(stack)-1]
+			stack = stack[:len(stack)-1]
+			if n := len(stack) + 1; n > depth && n <= len(manifestTrustPath) && manifestOnPath(append(stack, e)) {
+				parent, depth, pos = &e, n, offset
+			}
+		}
+	}
+
+	if parent == nil {
+		return nil, ErrInvalidManifest
+	}
+
+	//添加的元素在trustInfo之下时使用它的前缀
+	prefix := ""
+	if depth > 1 {
+		prefix = manifestPrefix(parent.name)
+	}
+
+	names := append(append([]string{}, manifestTrustPath[depth:]...), "requestedExecutionLevel")
+	var b strings.Builder
+	for i, name := range names {
+		b.WriteString(strings.Repeat("  ", depth+i) + "<" + prefix + name)
+		if name == "trustInfo" {
+			b.WriteString(` xmlns="urn:schemas-microsoft-com:asm.v3"`)
+		}
+
+		if i < len(names)-1 {
+			b.WriteString(">\n")
+		} else {
+			fmt.Fprintf(&b, ` level="%v" uiAccess="%v"/>`+"\n", level, ui)
+		}
+	}
+
+	for i := len(names) - 2; i >= 0; i-- {
+		b.WriteString(strings.Repeat("  ", depth+i) + "</" + prefix + names[i] + ">\n")
+	}
+
+	var insert string
+	end := pos
+	if bytes.HasSuffix(data[parent.start:parent.end], []byte("/>")) { //<trustInfo/>
+		pos, end = parent.end-2, parent.end
+		insert = ">\n" + b.String() + strings.Repeat("  ", depth-1) + "</" + manifestPrefix(parent.name) + parent.name.Local + ">"
+	} else {
+		//插入到结束标记所在的行之前
+		line := pos
+		for line > 0 && (data[line-1] == ' ' || data[line-1] == '\t') {
+			line--
+		}
+
+		if line > 0 && data[line-1] == '\n' {
+			pos, end = line, line
+		} else {
+			insert = "\n"
+		}
+		insert += b.String()
+	}
+
+	return append(append(append([]byte{}, data[:pos]...), insert...), data[end:]...), nil
+}
+
+func manifestPrefix(name xml.Name) string {
+	if name.Space == "" {
+		return ""
+	}
+
+	return name.Space + ":"
+}
+
+// 检查元素的路径是否是manifestTrustPath的前缀.
+func manifestOnPath(path []manifestElement) bool {
+	for i, e := range path {
+		if e.name.Local != manifestTrustPath[i] {
+			return false
+		}
+	}
+
+	return true
+}
+
+// 返回第一个RT_MANIFEST资源的XML, 没有时返回nil, nil.
+func (p *PeFile) Manifest() ([]byte, error) {
+	root, err := p.Resources()
+	if root == nil || err != nil {
+		return nil, err
+	}
+
+	if list := root.Find(ResID(RT_MANIFEST), ResID(CREATEPROCESS_MANIFEST_RESOURCE_ID)); len(list) > 0 {
+		return list[0].Data.Bytes()
+	}
+
+	for _, r := range root.List() {
+		if r.Type == ResID(RT_MANIFEST) {
+			return r.Data.Bytes()
+		}
+	}
+
+	return nil, nil
+}
+
+// 替换清单并重新生成资源节. 没有清单时exe使用ID 1, dll使用ID 2.
+func (p *PeFile) SetManifest(data []byte) error {
+	if _, err := ParseManifest(data); err != nil {
+		return err
+	}
+
+	root, err := p.Resources()
+	if err != nil {
+		return err
+	} else if root == nil {
+		root = &ResourceDirectory{}
+	}
+
+	name, lang := ResID(CREATEPROCESS_MANIFEST_RESOURCE_ID), uint16(0)
+	if p.File.FileHeader.Characteristics&IMAGE_FILE_DLL != 0 {
+		name = ResID(ISOLATIONAWARE_MANIFEST_RESOURCE_ID)
+	}
+
+	for _, r := range root.List() {
+		if r.Type == ResID(RT_MANIFEST) {
+			name, lang = r.Name, r.Lang
+			break
+		}
+	}
+
+	root.Set(ResID(RT_MANIFEST), name, lang, data)
+	return p.SetResources(root)
+}
+
+func (p *PeFile) RemoveManifest() error {
+	root, err := p.Resources()
+	if root == nil || err != nil {
+		return err
+	}
+
+	if !root.DeleteAll(ResID(RT_MANIFEST)) {
+		return nil
+	}
+
+	return p.SetResources(root)
+}
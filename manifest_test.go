@@ -0,0 +1,180 @@
+package pefile
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestManifest(t *testing.T) {
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	data, err := f.Manifest()
+	if err != nil || data == nil {
+		t.Fatalf("Manifest failed: %v", err)
+	}
+
+	m, err := ParseManifest(data)
+	if err != nil {
+		t.Fatalf("ParseManifest failed: %v", err)
+	}
+
+	if m.ExecutionLevel != "asInvoker" || m.UIAccess || len(m.SupportedOS) != 5 ||
+		m.SupportedOS[4] != "{8e0f7a12-bfb3-4fe8-b9a5-48fd50a15a9a}" || m.Identity != nil || len(m.Dependencies) != 0 {
+		t.Fatalf("manifest summary error: %+v", m)
+	}
+
+	data, err = SetManifestExecutionLevel(data, "requireAdministrator", false)
+	if err != nil {
+		t.Fatalf("SetManifestExecutionLevel failed: %v", err)
+	}
+
+	if err = f.SetManifest(data); err != nil {
+		t.Fatalf("SetManifest failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	g, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if data, err = g.Manifest(); err != nil {
+		t.Fatalf("Manifest failed: %v", err)
+	} else if m, err = ParseManifest(data); err != nil || m.ExecutionLevel != "requireAdministrator" || len(m.SupportedOS) != 5 {
+		t.Fatalf("manifest not changed: %+v", m)
+	}
+}
+
+func TestManifestInsert(t *testing.T) {
+	const manifest = `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<assembly xmlns="urn:schemas-microsoft-com:asm.v1" manifestVersion="1.0">
+  <assemblyIdentity type="win32" name="pefile.test" version="1.0.0.0"/>
+  <dependency>
+    <dependentAssembly>
+      <assemblyIdentity type="win32" name="Microsoft.Windows.Common-Controls" version="6.0.0.0" processorArchitecture="*" publicKeyToken="6595b64144ccf1df" language="*"/>
+    </dependentAssembly>
+  </dependency>
+  <application xmlns="urn:schemas-microsoft-com:asm.v3">
+    <windowsSettings>
+      <dpiAware xmlns="http://schemas.microsoft.com/SMI/2005/WindowsSettings">true/pm</dpiAware>
+      <dpiAwareness xmlns="http://schemas.microsoft.com/SMI/2016/WindowsSettings">PerMonitorV2</dpiAwareness>
+    </windowsSettings>
+  </application>
+</assembly>
+`
+	data, err := SetManifestExecutionLevel([]byte(manifest), "highestAvailable", true)
+	if err != nil || !strings.Contains(string(data), "<trustInfo") {
+		t.Fatalf("SetManifestExecutionLevel failed: %v", err)
+	}
+
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if data, err := f.Manifest(); data != nil || err != nil {
+		t.Fatalf("hello_vc_exe should not have manifest")
+	}
+
+	if err = f.SetManifest([]byte("<assembly>")); err == nil {
+		t.Fatalf("SetManifest should fail with invalid xml")
+	}
+
+	if err = f.SetManifest(data); err != nil {
+		t.Fatalf("SetManifest failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	g, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if data, err = g.Manifest(); err != nil || data == nil {
+		t.Fatalf("Manifest failed: %v", err)
+	}
+
+	m, err := ParseManifest(data)
+	if err != nil {
+		t.Fatalf("ParseManifest failed: %v", err)
+	}
+
+	if m.ExecutionLevel != "highestAvailable" || !m.UIAccess || m.DPIAware != "true/pm" || m.DPIAwareness != "PerMonitorV2" ||
+		m.Identity == nil || m.Identity.Name != "pefile.test" || len(m.Dependencies) != 1 || m.Dependencies[0].PublicKeyToken != "6595b64144ccf1df" {
+		t.Fatalf("manifest summary error: %+v", m)
+	}
+
+	root, _ := g.Resources()
+	if len(root.Find(ResID(RT_MANIFEST), ResID(CREATEPROCESS_MANIFEST_RESOURCE_ID))) != 1 {
+		t.Fatalf("manifest id error")
+	}
+}
+
+func TestManifestExecutionLevel(t *testing.T) {
+	//Visual Studio默认的app.manifest, 注释中的示例在真正的元素之前
+	const template = `<?xml version="1.0" encoding="utf-8"?>
+<assembly manifestVersion="1.0" xmlns="urn:schemas-microsoft-com:asm.v1">
+  <assemblyIdentity version="1.0.0.0" name="MyApplication.app"/>
+  <trustInfo xmlns="urn:schemas-microsoft-com:asm.v2">
+    <security>
+      <requestedPrivileges xmlns="urn:schemas-microsoft-com:asm.v3">
+        <!-- UAC Manifest Options
+             <requestedExecutionLevel level="asInvoker" uiAccess="false" />
+             <requestedExecutionLevel level="requireAdministrator" uiAccess="false" />
+        -->
+        <requestedExecutionLevel level="asInvoker" uiAccess="false" />
+      </requestedPrivileges>
+    </security>
+  </trustInfo>
+</assembly>
+`
+	data, err := SetManifestExecutionLevel([]byte(template), "highestAvailable", false)
+	if err != nil {
+		t.Fatalf("SetManifestExecutionLevel failed: %v", err)
+	}
+
+	if m, err := ParseManifest(data); err != nil || m.ExecutionLevel != "highestAvailable" {
+		t.Fatalf("execution level not changed: %v %+v", err, m)
+	} else if !strings.Contains(string(data), `<requestedExecutionLevel level="requireAdministrator" uiAccess="false" />`) {
+		t.Fatalf("comment changed: %s", data)
+	}
+
+	if _, err = SetManifestExecutionLevel([]byte(template), `asInvoker" uiAccess="true`, false); err == nil {
+		t.Fatalf("invalid level should fail")
+	}
+
+	//已有trustInfo时添加到其中
+	for _, trust := range []string{
+		`<ms_asmv3:trustInfo xmlns:ms_asmv3="urn:schemas-microsoft-com:asm.v3">
+    <ms_asmv3:security>
+    </ms_asmv3:security>
+  </ms_asmv3:trustInfo>`,
+		`<trustInfo xmlns="urn:schemas-microsoft-com:asm.v3"/>`,
+	} {
+		manifest := "<assembly xmlns=\"urn:schemas-microsoft-com:asm.v1\" manifestVersion=\"1.0\">\n  " + trust + "\n</assembly>\n"
+		data, err = SetManifestExecutionLevel([]byte(manifest), "requireAdministrator", true)
+		if err != nil {
+			t.Fatalf("SetManifestExecutionLevel failed: %v", err)
+		}
+
+		m, err := ParseManifest(data)
+		if err != nil || m.ExecutionLevel != "requireAdministrator" || !m.UIAccess || strings.Count(string(data), "trustInfo ") != 1 ||
+			strings.Count(string(data), "security>") != 2 {
+			t.Fatalf("trustInfo not reused: %v\n%s", err, data)
+		}
+	}
+}
@@ -0,0 +1,175 @@
+package pefile
+
+import "errors"
+
+var ErrInvalidMenu = errors.New("pefile: invalid menu template")
+
+const maxMenuDepth = 16
+
+type Menu struct {
+	Extended bool //MENUEX
+	HelpID   uint32
+	Items    []*MenuItem
+}
+
+// Popup为true时Items是子菜单, 子菜单为空时写出一个空的项(分隔线)作为结束.
+// Flags只用于MENU, 不包括MF_POPUP和MF_END; Type, State和HelpID只用于MENUEX, MENU中的ID只有16位.
+type MenuItem struct {
+	Popup  bool
+	Flags  uint16
+	Type   uint32
+	State  uint32
+	ID     uint32
+	HelpID uint32
+	Text   string
+	Items  []*MenuItem
+}
+
+func ParseMenu(data []byte) (*Menu, error) {
+	r := &templateReader{data: data}
+	m := &Menu{}
+	version, offset := r.u16(), r.u16()
+	switch version {
+	case 0:
+		r.pos += int(offset)
+		if r.pos < len(data) {
+			m.Items = parseMenuItems(r, 0)
+		}
+	case MENUEX_TEMPLATE_VER:
+		if offset >= 4 {
+			m.HelpID = r.u32()
+		}
+		r.pos = 4 + int(offset)
+		m.Extended = true
+		if r.pos < len(data) {
+			m.Items = parseMenuExItems(r, 0)
+		}
+	default:
+		return nil, ErrInvalidMenu
+	}
+
+	if r.err {
+		return nil, ErrInvalidMenu
+	}
+
+	return m, nil
+}
+
+func parseMenuItems(r *templateReader, depth int) (ret []*MenuItem) {
+	if depth > maxMenuDepth {
+		r.err = true
+		return
+	}
+
+	for !r.err {
+		item := &MenuItem{}
+		flags := r.u16()
+		item.Popup, item.Flags = flags&MF_POPUP != 0, flags&^(MF_POPUP|MF_END)
+		if !item.Popup {
+			item.ID = uint32(r.u16())
+		}
+
+		item.Text = r.sz()
+		if item.Popup {
+			item.Items = parseMenuItems(r, depth+1)
+		}
+		ret = append(ret, item)
+
+		if flags&MF_END != 0 {
+			break
+		}
+	}
+
+	return
+}
+
+func parseMenuExItems(r *templateReader, depth int) (ret []*MenuItem) {
+	if depth > maxMenuDepth {
+		r.err = true
+		return
+	}
+
+	for !r.err {
+		item := &MenuItem{}
+		item.Type, item.State, item.ID = r.u32(), r.u32(), r.u32()
+		flags := r.u16()
+		item.Text = r.sz()
+		r.align()
+
+		if item.Popup = flags&MFR_POPUP != 0; item.Popup {
+			item.HelpID = r.u32()
+			item.Items = parseMenuExItems(r, depth+1)
+		}
+		ret = append(ret, item)
+
+		if flags&MFR_END != 0 {
+			break
+		}
+	}
+
+	return
+}
+
+func (m *Menu) Bytes() []byte {
+	w := &templateWriter{}
+	if m.Extended {
+		w.put(uint16(MENUEX_TEMPLATE_VER), uint16(4), m.HelpID)
+		writeMenuExItems(w, m.Items)
+	} else {
+		w.put(uint16(0), uint16(0))
+		writeMenuItems(w, m.Items)
+	}
+
+	return w.Bytes()
+}
+
+// 模板中子菜单至少有一项.
+var emptyMenuItems = []*MenuItem{{}}
+
+func writeMenuItems(w *templateWriter, items []*MenuItem) {
+	if len(items) == 0 {
+		items = emptyMenuItems
+	}
+
+	for i, item := range items {
+		flags := item.Flags
+		if i == len(items)-1 {
+			flags |= MF_END
+		}
+
+		if item.Popup {
+			w.put(flags | MF_POPUP)
+		} else {
+			w.put(flags, uint16(item.ID))
+		}
+
+		w.sz(item.Text)
+		if item.Popup {
+			writeMenuItems(w, item.Items)
+		}
+	}
+}
+
+func writeMenuExItems(w *templateWriter, items []*MenuItem) {
+	if len(items) == 0 {
+		items = emptyMenuItems
+	}
+
+	for i, item := range items {
+		flags := uint16(0)
+		if item.Popup {
+			flags |= MFR_POPUP
+		}
+		if i == len(items)-1 {
+			flags |= MFR_END
+		}
+
+		w.put(item.Type, item.State, item.ID, flags)
+		w.sz(item.Text)
+		w.align()
+		if item.Popup {
+			w.put(item.HelpID)
+			writeMenuExItems(w, item.Items)
+		}
+	}
+}
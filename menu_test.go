@@ -0,0 +1,80 @@
+package pefile
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestMenu(t *testing.T) {
+	m := &Menu{Items: []*MenuItem{
+		{Popup: true, Text: "&File", Items: []*MenuItem{
+			{ID: 100, Text: "&Open"},
+			{},
+			{ID: 101, Flags: 0x1, Text: "E&xit"},
+		}},
+		{ID: 200, Text: "&Help"},
+	}}
+
+	data := m.Bytes()
+	expect := []byte{0, 0, 0, 0, 0x10, 0}
+	expect = append(expect, encodeUTF16("&File\x00")...)
+	expect = append(expect, 0, 0, 100, 0)
+	expect = append(expect, encodeUTF16("&Open\x00")...)
+	expect = append(expect, 0, 0, 0, 0, 0, 0)
+	expect = append(expect, 0x81, 0, 101, 0)
+	expect = append(expect, encodeUTF16("E&xit\x00")...)
+	expect = append(expect, 0x80, 0, 200, 0)
+	expect = append(expect, encodeUTF16("&Help\x00")...)
+	if bytes.Compare(data, expect) != 0 {
+		t.Fatalf("menu data error: %x", data)
+	}
+
+	n, err := ParseMenu(data)
+	if err != nil || !reflect.DeepEqual(n, m) {
+		t.Fatalf("ParseMenu failed: %v", err)
+	}
+
+	if _, err = ParseMenu(data[:len(data)-2]); err == nil {
+		t.Fatalf("ParseMenu should fail with truncated data")
+	}
+
+	m = &Menu{Extended: true, HelpID: 1, Items: []*MenuItem{
+		{Popup: true, ID: 10, HelpID: 2, Text: "文件", Items: []*MenuItem{
+			{ID: 100, State: 0x8, Text: "Open"},
+			{Type: 0x800},
+		}},
+		{ID: 200, Type: 0x4000, Text: "Help"},
+	}}
+
+	data = m.Bytes()
+	if len(data)%4 != 0 {
+		t.Fatalf("menuex size %v not aligned", len(data))
+	}
+
+	if n, err = ParseMenu(data); err != nil || !reflect.DeepEqual(n, m) {
+		t.Fatalf("ParseMenu failed: %v %+v", err, n)
+	}
+
+	if bytes.Compare(n.Bytes(), data) != 0 {
+		t.Fatalf("menuex round trip failed")
+	}
+
+	//空的子菜单写出一个空的项, 后面的项不会被读成子菜单的项
+	for _, extended := range []bool{false, true} {
+		m = &Menu{Extended: extended, Items: []*MenuItem{
+			{Popup: true, Text: "&Empty"},
+			{ID: 200, Text: "&Help"},
+		}}
+
+		data = m.Bytes()
+		if n, err = ParseMenu(data); err != nil || len(n.Items) != 2 || !reflect.DeepEqual(n.Items[0].Items, []*MenuItem{{}}) ||
+			!reflect.DeepEqual(n.Items[1], m.Items[1]) {
+			t.Fatalf("empty popup error: %v %+v", err, n)
+		}
+
+		if bytes.Compare(n.Bytes(), data) != 0 {
+			t.Fatalf("empty popup round trip failed")
+		}
+	}
+}
@@ -0,0 +1,218 @@
+package pefile
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+)
+
+// 使输出可以重现: 所有时间戳改为timestamp, 删除rich头, CodeView的GUID改为内容的哈希,
+// 最后重新计算CheckSum. 返回修改的内容.
+// 导入描述符的时间戳非0表示已绑定(-1表示使用绑定导入目录), 所以总是清零(没有INT的除外),
+// 并删除绑定导入目录.
+func (p *PeFile) Normalize(timestamp uint32) (report []string, err error) {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return nil, ErrNoOptionHeader
+	}
+
+	change := func(format string, v ...interface{}) {
+		report = append(report, fmt.Sprintf(format, v...))
+	}
+
+	if old := p.File.FileHeader.TimeDateStamp; old != timestamp {
+		p.File.FileHeader.TimeDateStamp = timestamp
+		change("FileHeader.TimeDateStamp: %#x -> %#x", old, timestamp)
+	}
+
+	if dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_EXPORT); dir.VirtualAddress != 0 {
+		if err = p.normalizeTimestamp(dir.VirtualAddress+4, timestamp, "export directory", change); err != nil {
+			return
+		}
+	}
+
+	if err = p.normalizeImports(change); err != nil {
+		return
+	}
+
+	if err = p.normalizeResources(timestamp, change); err != nil {
+		return
+	}
+
+	entries, err := p.DebugDirectory()
+	if err != nil {
+		return
+	}
+
+	for _, e := range entries {
+		if e.TimeDateStamp != timestamp {
+			change("debug directory type %d TimeDateStamp: %#x -> %#x", e.Type, e.TimeDateStamp, timestamp)
+		}
+	}
+
+	if err = p.SetDebugTimestamps(timestamp); err != nil {
+		return
+	}
+
+	if p.StripRichHeader() {
+		change("rich header removed")
+	}
+
+	old := h.CheckSum()
+	h.setCheckSum(0)
+	if err = p.normalizeCodeView(timestamp, change); err != nil {
+		return
+	}
+
+	if err = p.WriteToWithOptions(ioutil.Discard, WriteOptions{UpdateChecksum: true}); err != nil {
+		return
+	}
+
+	if sum := h.CheckSum(); sum != old {
+		change("CheckSum: %#x -> %#x", old, sum)
+	}
+
+	return
+}
+
+func (p *PeFile) normalizeTimestamp(rva, timestamp uint32, name string, change func(string, ...interface{})) error {
+	data, err := p.ReadRVA(rva, 4)
+	if err != nil {
+		return err
+	}
+
+	if old := binary.LittleEndian.Uint32(data); old != timestamp {
+		binary.LittleEndian.PutUint32(data, timestamp)
+		if err = p.WriteRVA(rva, data); err != nil {
+			return err
+		}
+		change("%s TimeDateStamp: %#x -> %#x", name, old, timestamp)
+	}
+
+	return nil
+}
+
+func (p *PeFile) normalizeImports(change func(string, ...interface{})) error {
+	libs, err := p.Imports()
+	if err != nil {
+		return err
+	}
+
+	dir := p.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_IMPORT)
+	for i, lib := range libs {
+		d := lib.Descriptor
+		if d.TimeDateStamp == 0 || d.OriginalFirstThunk == 0 {
+			continue
+		}
+
+		rva := dir.VirtualAddress + uint32(i)*imageImportDescriptorSize
+		if err = p.normalizeTimestamp(rva+4, 0, "import "+lib.Name, change); err != nil {
+			return err
+		}
+	}
+
+	//绑定导入目录中是DLL的时间戳. 通常在头中, WriteTo不保留, 在节中时清零
+	h := p.OptionHeaderView()
+	bound := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BOUND_IMPORT)
+	if bound == (pe.DataDirectory{}) {
+		return nil
+	}
+
+	if _, err = p.ReadRVA(bound.VirtualAddress, bound.Size); err == nil {
+		if err = p.WriteRVA(bound.VirtualAddress, make([]byte, bound.Size)); err != nil {
+			return err
+		}
+	}
+
+	if err = h.SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BOUND_IMPORT, pe.DataDirectory{}); err != nil {
+		return err
+	}
+
+	change("bound import directory removed")
+	return nil
+}
+
+// 直接修改资源目录中的时间戳, 不重新生成资源节.
+func (p *PeFile) normalizeResources(timestamp uint32, change func(string, ...interface{})) error {
+	root, err := p.Resources()
+	if root == nil || err != nil {
+		return err
+	}
+
+	dir := p.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_RESOURCE)
+	data, err := p.ReadRVA(dir.VirtualAddress, dir.Size)
+	if err != nil {
+		return err
+	}
+
+	//Resources已经检查过目录的范围和循环
+	changed := 0
+	queue := []uint32{0}
+	for len(queue) > 0 {
+		offset := queue[0]
+		queue = queue[1:]
+
+		if binary.LittleEndian.Uint32(data[offset+4:]) != timestamp {
+			binary.LittleEndian.PutUint32(data[offset+4:], timestamp)
+			changed++
+		}
+
+		count := uint32(binary.LittleEndian.Uint16(data[offset+12:])) + uint32(binary.LittleEndian.Uint16(data[offset+14:]))
+		for i := uint32(0); i < count; i++ {
+			next := binary.LittleEndian.Uint32(data[offset+ImageResourceDirectorySize+i*ImageResourceDirectoryEntrySize+4:])
+			if next&IMAGE_RESOURCE_DATA_IS_DIRECTORY != 0 {
+				queue = append(queue, next&^IMAGE_RESOURCE_DATA_IS_DIRECTORY)
+			}
+		}
+	}
+
+	if changed == 0 {
+		return nil
+	}
+
+	change("%d resource directory TimeDateStamp -> %#x", changed, timestamp)
+	return p.WriteRVA(dir.VirtualAddress, data)
+}
+
+// GUID为0时计算整个文件的哈希, 所以结果只和其它内容有关. NB10的Signature是时间戳.
+func (p *PeFile) normalizeCodeView(timestamp uint32, change func(string, ...interface{})) error {
+	cv, err := p.CodeView()
+	if cv == nil || err != nil {
+		return err
+	}
+
+	old := *cv
+	if cv.Format == codeViewNB10 {
+		cv.Signature = timestamp
+	} else {
+		cv.GUID = [16]byte{}
+		if err = p.SetCodeView(cv); err != nil {
+			return err
+		}
+
+		var buf bytes.Buffer
+		if err = p.WriteTo(&buf); err != nil {
+			return err
+		}
+
+		sum := sha256.Sum256(buf.Bytes())
+		copy(cv.GUID[:], sum[:])
+	}
+
+	if err = p.SetCodeView(cv); err != nil {
+		return err
+	}
+
+	if old != *cv {
+		if cv.Format == codeViewNB10 {
+			change("CodeView Signature: %#x -> %#x", old.Signature, cv.Signature)
+		} else {
+			change("CodeView GUID: %s -> %s", old.GUIDString(), cv.GUIDString())
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,141 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+// 模拟两次编译, 只有时间戳, rich头和GUID不同.
+func testBuild(t *testing.T, timestamp uint32, guid byte) *PeFile {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+
+	addTestExports(t, f)
+	addTestDebugDirectory(t, f)
+	f.File.FileHeader.TimeDateStamp = timestamp
+	if err = f.SetDebugTimestamps(timestamp); err != nil {
+		t.Fatalf("SetDebugTimestamps failed: %v", err)
+	}
+
+	cv := *testCodeView
+	cv.GUID[0] = guid
+	if err = f.SetCodeView(&cv); err != nil {
+		t.Fatalf("SetCodeView failed: %v", err)
+	}
+
+	if err = f.SetVersionInfo(NewVersionInfo(0x409, 1200)); err != nil {
+		t.Fatalf("SetVersionInfo failed: %v", err)
+	}
+
+	//第一个DLL使用新式绑定
+	dir := f.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_IMPORT)
+	stamp := make([]byte, 4)
+	binary.LittleEndian.PutUint32(stamp, 0xffffffff)
+	if err = f.WriteRVA(dir.VirtualAddress+4, stamp); err != nil {
+		t.Fatalf("WriteRVA failed: %v", err)
+	}
+
+	bound := make([]byte, 0x20)
+	binary.LittleEndian.PutUint32(bound, timestamp)
+	binary.LittleEndian.PutUint16(bound[4:], 16)
+	copy(bound[16:], "KERNEL32.dll")
+	f.AddSection(".bound", bound, IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+	va := f.mySections[len(f.mySections)-1].virtualAddress
+	if err = f.OptionHeaderView().SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BOUND_IMPORT, pe.DataDirectory{VirtualAddress: va, Size: 0x20}); err != nil {
+		t.Fatalf("SetDataDirectory failed: %v", err)
+	}
+
+	root, _ := f.Resources()
+	root.TimeDateStamp = timestamp
+	if err = f.SetResources(root); err != nil {
+		t.Fatalf("SetResources failed: %v", err)
+	}
+
+	return f
+}
+
+func TestNormalize(t *testing.T) {
+	var outputs [2][]byte
+	for i, build := range []struct {
+		timestamp uint32
+		guid      byte
+	}{{0x5b5577b0, 1}, {0x61000000, 2}} {
+		f := testBuild(t, build.timestamp, build.guid)
+		defer f.Close()
+
+		report, err := f.Normalize(0)
+		if err != nil {
+			t.Fatalf("Normalize failed: %v", err)
+		}
+
+		all := strings.Join(report, "\n")
+		for _, s := range []string{"FileHeader.TimeDateStamp", "export directory", "resource directory", "debug directory", "rich header", "CodeView GUID", "CheckSum",
+			"bound import directory", "import KERNEL32.dll"} {
+			if !strings.Contains(all, s) {
+				t.Fatalf("report should contain %v: %v", s, all)
+			}
+		}
+
+		var buf bytes.Buffer
+		if err = f.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+		outputs[i] = buf.Bytes()
+
+		//再次执行没有修改
+		if report, err = f.Normalize(0); err != nil || len(report) != 0 {
+			t.Fatalf("Normalize should not change anything: %v %v", report, err)
+		}
+	}
+
+	if bytes.Compare(outputs[0], outputs[1]) != 0 {
+		t.Fatalf("normalized outputs differ")
+	}
+
+	g, err := New(bytes.NewReader(outputs[0]))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if ok, err := g.VerifyChecksum(); !ok || err != nil {
+		t.Fatalf("checksum error: %v", err)
+	}
+
+	if r, _ := g.RichHeader(); r != nil || g.File.FileHeader.TimeDateStamp != 0 {
+		t.Fatalf("header not normalized")
+	}
+
+	cv, err := g.CodeView()
+	if err != nil || cv.GUID == [16]byte{} || cv.GUID == testCodeView.GUID || cv.PDBPath != testCodeView.PDBPath {
+		t.Fatalf("CodeView error: %v %+v", err, cv)
+	}
+
+	exports, err := g.Exports()
+	if err != nil || exports.Directory.TimeDateStamp != 0 {
+		t.Fatalf("export timestamp error: %v", err)
+	}
+
+	if dir := g.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_DEBUG); dir.Size == 0 {
+		t.Fatalf("debug directory removed")
+	}
+
+	if dir := g.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BOUND_IMPORT); dir != (pe.DataDirectory{}) {
+		t.Fatalf("bound import directory not removed")
+	}
+
+	libs, err := g.Imports()
+	if err != nil || len(libs) == 0 {
+		t.Fatalf("Imports failed: %v", err)
+	}
+
+	for _, lib := range libs {
+		if lib.Descriptor.TimeDateStamp != 0 {
+			t.Fatalf("import %v TimeDateStamp not cleared", lib.Name)
+		}
+	}
+}
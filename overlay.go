@@ -0,0 +1,131 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"io"
+	"io/ioutil"
+)
+
+type Overlay struct {
+	Offset int64 //在打开的文件中的偏移, SetOverlay设置的数据为-1
+	Size   int64
+	sr     *io.SectionReader
+}
+
+func (o *Overlay) Open() io.ReadSeeker {
+	return io.NewSectionReader(o.sr, 0, o.Size)
+}
+
+func (o *Overlay) Data() ([]byte, error) {
+	return ioutil.ReadAll(o.Open())
+}
+
+// 没有附加数据时返回nil. 打开的文件中证书表之后的数据也属于附加数据, 写出时和其它附加数据一起放在证书表之前.
+func (p *PeFile) Overlay() *Overlay {
+	return p.overlay
+}
+
+func (p *PeFile) SetOverlay(data []byte) {
+	if len(data) == 0 {
+		p.overlay = nil
+	} else {
+		r := bytes.NewReader(append([]byte(nil), data...))
+		p.overlay = &Overlay{-1, int64(len(data)), io.NewSectionReader(r, 0, int64(len(data)))}
+	}
+}
+
+func (p *PeFile) RemoveOverlay() {
+	p.overlay = nil
+}
+
+func (p *PeFile) loadOverlay() (err error) {
+	size, err := readerSize(p.r)
+	if err != nil {
+		return
+	}
+
+	end, err := p.dataEnd()
+	var r io.ReaderAt = p.r
+	if p.certificates != nil && p.certificateOffset >= end && p.certificateOffset < size { //跳过证书表
+		r = &holeReaderAt{p.r, p.certificateOffset, p.certificateSize}
+		size -= p.certificateSize
+	}
+
+	if err == nil && end < size {
+		p.overlay = &Overlay{end, size - end, io.NewSectionReader(r, end, size-end)}
+	}
+
+	return
+}
+
+// 读取时跳过r中从offset开始的size字节.
+type holeReaderAt struct {
+	r      io.ReaderAt
+	offset int64
+	size   int64
+}
+
+func (h *holeReaderAt) ReadAt(b []byte, off int64) (n int, err error) {
+	if off < h.offset {
+		head := b
+		if int64(len(head)) > h.offset-off {
+			head = head[:h.offset-off]
+		}
+
+		if n, err = h.r.ReadAt(head, off); err != nil || n == len(b) {
+			return
+		}
+		b, off = b[n:], h.offset
+	}
+
+	m, err := h.r.ReadAt(b, off+h.size)
+	return n + m, err
+}
+
+// 返回文件中头, 节数据, 重定位和符号表占用的结束位置.
+func (p *PeFile) dataEnd() (end int64, err error) {
+	max := func(v int64) {
+		if v > end {
+			end = v
+		}
+	}
+
+	max(int64(len(p.dosHeader) + binary.Size(p.File.FileHeader) + int(p.File.SizeOfOptionalHeader) +
+		len(p.File.Sections)*binary.Size(pe.SectionHeader32{})))
+	if h := p.OptionHeaderView(); h != nil {
+		max(int64(h.SizeOfHeaders()))
+	}
+
+	for _, s := range p.File.Sections {
+		if s.Size > 0 {
+			max(int64(s.Offset) + int64(s.Size))
+		}
+
+		if s.NumberOfRelocations > 0 {
+			max(int64(s.PointerToRelocations) + int64(s.NumberOfRelocations)*10)
+		}
+	}
+
+	if fh := p.File.FileHeader; fh.PointerToSymbolTable > 0 && fh.NumberOfSymbols > 0 {
+		symbolEnd := int64(fh.PointerToSymbolTable) + int64(fh.NumberOfSymbols)*pe.COFFSymbolSize
+		var stringSize [4]byte
+		if _, err = p.r.ReadAt(stringSize[:], symbolEnd); err == nil {
+			max(symbolEnd + int64(binary.LittleEndian.Uint32(stringSize[:])))
+		} else if err == io.EOF {
+			max(symbolEnd)
+			err = nil
+		}
+	}
+
+	return
+}
+
+func (p *PeFile) writeOverlay(w io.Writer) (err error) {
+	if p.overlay != nil {
+		_, err = io.Copy(w, p.overlay.Open())
+	}
+
+	return
+}
@@ -16,6 +16,14 @@ const (
 	IMAGE_REL_BASED_MIPS_JMPADDR16 = 9
 	IMAGE_REL_BASED_IA64_IMM64     = 9
 	IMAGE_REL_BASED_DIR64          = 10
+
+	IMAGE_REL_BASED_ARM_MOV32           = 5
+	IMAGE_REL_BASED_RISCV_HIGH20        = 5
+	IMAGE_REL_BASED_THUMB_MOV32         = 7
+	IMAGE_REL_BASED_RISCV_LOW12I        = 7
+	IMAGE_REL_BASED_RISCV_LOW12S        = 8
+	IMAGE_REL_BASED_LOONGARCH32_MARK_LA = 8
+	IMAGE_REL_BASED_LOONGARCH64_MARK_LA = 8
 )
 
 type ImageDataDirectory struct {
@@ -128,7 +136,178 @@ type ImageImportDescriptor struct {
 	FirstThunk         uint32
 }
 
-var peHeader = peHeaderB8
+type ImageExportDirectory struct {
+	Characteristics       uint32
+	TimeDateStamp         uint32
+	MajorVersion          uint16
+	MinorVersion          uint16
+	Name                  uint32
+	Base                  uint32
+	NumberOfFunctions     uint32
+	NumberOfNames         uint32
+	AddressOfFunctions    uint32
+	AddressOfNames        uint32
+	AddressOfNameOrdinals uint32
+}
+
+const ImageExportDirectorySize = 40
+
+type ImageResourceDirectory struct {
+	Characteristics      uint32
+	TimeDateStamp        uint32
+	MajorVersion         uint16
+	MinorVersion         uint16
+	NumberOfNamedEntries uint16
+	NumberOfIdEntries    uint16
+}
+
+type ImageResourceDirectoryEntry struct {
+	Name, OffsetToData uint32
+}
+
+type ImageResourceDataEntry struct {
+	OffsetToData, Size, CodePage, Reserved uint32
+}
+
+const (
+	ImageResourceDirectorySize      = 16
+	ImageResourceDirectoryEntrySize = 8
+	ImageResourceDataEntrySize      = 16
+)
+
+//ImageResourceDirectoryEntry
+const (
+	IMAGE_RESOURCE_NAME_IS_STRING    = 0x80000000
+	IMAGE_RESOURCE_DATA_IS_DIRECTORY = 0x80000000
+)
+
+//Resource type
+const (
+	RT_CURSOR       = 1
+	RT_BITMAP       = 2
+	RT_ICON         = 3
+	RT_MENU         = 4
+	RT_DIALOG       = 5
+	RT_STRING       = 6
+	RT_FONTDIR      = 7
+	RT_FONT         = 8
+	RT_ACCELERATOR  = 9
+	RT_RCDATA       = 10
+	RT_MESSAGETABLE = 11
+	RT_GROUP_CURSOR = 12
+	RT_GROUP_ICON   = 14
+	RT_VERSION      = 16
+	RT_DLGINCLUDE   = 17
+	RT_PLUGPLAY     = 19
+	RT_VXD          = 20
+	RT_ANICURSOR    = 21
+	RT_ANIICON      = 22
+	RT_HTML         = 23
+	RT_MANIFEST     = 24
+)
+
+type VSFixedFileInfo struct {
+	Signature        uint32
+	StrucVersion     uint32
+	FileVersionMS    uint32
+	FileVersionLS    uint32
+	ProductVersionMS uint32
+	ProductVersionLS uint32
+	FileFlagsMask    uint32
+	FileFlags        uint32
+	FileOS           uint32
+	FileType         uint32
+	FileSubtype      uint32
+	FileDateMS       uint32
+	FileDateLS       uint32
+}
+
+const (
+	VSFixedFileInfoSize     = 52
+	VS_FFI_SIGNATURE        = 0xfeef04bd
+	VS_FFI_STRUCVERSION     = 0x00010000
+	VS_FFI_FILEFLAGSMASK    = 0x0000003f
+	VOS_NT_WINDOWS32        = 0x00040004
+	VFT_APP                 = 0x00000001
+	VFT_DLL                 = 0x00000002
+	VS_VERSION_INFO_KEY     = "VS_VERSION_INFO"
+	VS_STRING_FILE_INFO_KEY = "StringFileInfo"
+	VS_VAR_FILE_INFO_KEY    = "VarFileInfo"
+	VS_TRANSLATION_KEY      = "Translation"
+)
+
+//COFF对象的重定位类型
+const (
+	IMAGE_REL_I386_DIR32NB   = 0x0007
+	IMAGE_REL_AMD64_ADDR32NB = 0x0003
+	IMAGE_REL_ARM_ADDR32NB   = 0x0002
+	IMAGE_REL_ARM64_ADDR32NB = 0x0002
+)
+
+const (
+	COFFSymbolSize         = 18
+	IMAGE_SYM_ABSOLUTE     = -1
+	IMAGE_SYM_CLASS_STATIC = 3
+)
+
+//对话框和菜单模板
+const (
+	DS_SETFONT          = 0x40
+	DLGTEMPLATEEX_SIGN  = 0xffff
+	MF_POPUP            = 0x0010
+	MF_END              = 0x0080
+	MFR_POPUP           = 0x01
+	MFR_END             = 0x80
+	MENUEX_TEMPLATE_VER = 1
+)
+
+type ImageDebugDirectory struct {
+	Characteristics  uint32
+	TimeDateStamp    uint32
+	MajorVersion     uint16
+	MinorVersion     uint16
+	Type             uint32
+	SizeOfData       uint32
+	AddressOfRawData uint32
+	PointerToRawData uint32
+}
+
+const ImageDebugDirectorySize = 28
+
+//ImageDebugDirectory.Type
+const (
+	IMAGE_DEBUG_TYPE_UNKNOWN               = 0
+	IMAGE_DEBUG_TYPE_COFF                  = 1
+	IMAGE_DEBUG_TYPE_CODEVIEW              = 2
+	IMAGE_DEBUG_TYPE_FPO                   = 3
+	IMAGE_DEBUG_TYPE_MISC                  = 4
+	IMAGE_DEBUG_TYPE_EXCEPTION             = 5
+	IMAGE_DEBUG_TYPE_FIXUP                 = 6
+	IMAGE_DEBUG_TYPE_OMAP_TO_SRC           = 7
+	IMAGE_DEBUG_TYPE_OMAP_FROM_SRC         = 8
+	IMAGE_DEBUG_TYPE_BORLAND               = 9
+	IMAGE_DEBUG_TYPE_RESERVED10            = 10
+	IMAGE_DEBUG_TYPE_CLSID                 = 11
+	IMAGE_DEBUG_TYPE_VC_FEATURE            = 12
+	IMAGE_DEBUG_TYPE_POGO                  = 13
+	IMAGE_DEBUG_TYPE_ILTCG                 = 14
+	IMAGE_DEBUG_TYPE_MPX                   = 15
+	IMAGE_DEBUG_TYPE_REPRO                 = 16
+	IMAGE_DEBUG_TYPE_EMBEDDED_PORTABLE_PDB = 17
+	IMAGE_DEBUG_TYPE_SPGO                  = 18
+	IMAGE_DEBUG_TYPE_PDBCHECKSUM           = 19
+	IMAGE_DEBUG_TYPE_EX_DLLCHARACTERISTICS = 20
+)
+
+//IMAGE_DEBUG_TYPE_EX_DLLCHARACTERISTICS
+const (
+	IMAGE_DLLCHARACTERISTICS_EX_CET_COMPAT                                 = 0x01
+	IMAGE_DLLCHARACTERISTICS_EX_CET_COMPAT_STRICT_MODE                     = 0x02
+	IMAGE_DLLCHARACTERISTICS_EX_CET_SET_CONTEXT_IP_VALIDATION_RELAXED_MODE = 0x04
+	IMAGE_DLLCHARACTERISTICS_EX_CET_DYNAMIC_APIS_ALLOW_IN_PROC             = 0x08
+	IMAGE_DLLCHARACTERISTICS_EX_FORWARD_CFI_COMPAT                         = 0x40
+	IMAGE_DLLCHARACTERISTICS_EX_HOTPATCH_COMPATIBLE                        = 0x80
+)
 
 var peHeader80 = []byte{
 	0x4D, 0x5A, 0x90, 0x00, 0x03, 0x00, 0x00, 0x00,
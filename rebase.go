@@ -0,0 +1,157 @@
+package pefile
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+)
+
+var ErrRelocsStripped = errors.New("pefile: relocations stripped")
+
+// 按照加载器的方式把映像重定位到newBase, 修改节数据和ImageBase.
+func (p *PeFile) Rebase(newBase uint64) error {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return ErrNoOptionHeader
+	}
+
+	if p.File.Characteristics&IMAGE_FILE_RELOCS_STRIPPED != 0 {
+		return ErrRelocsStripped
+	}
+
+	blocks, err := p.ReadBaseRelocations()
+	if err != nil {
+		return err
+	}
+
+	type patch struct {
+		rva  uint32
+		data []byte
+	}
+
+	delta := newBase - h.ImageBase()
+	var patches []patch
+	for _, b := range blocks {
+		for _, e := range b.Entries {
+			size := baseRelocationSize(p.File.Machine, e.Type)
+			if size == 0 {
+				return fmt.Errorf("pefile: unsupported base relocation %v at %#x",
+					BaseRelocationTypeName(p.File.Machine, e.Type), e.RVA)
+			}
+
+			data, err := p.ReadRVA(e.RVA, size)
+			if err != nil {
+				return err
+			}
+
+			if err = applyBaseRelocation(p.File.Machine, data, e, delta); err != nil {
+				return err
+			}
+			patches = append(patches, patch{e.RVA, data})
+		}
+	}
+
+	if err = h.SetImageBase(newBase); err != nil {
+		return err
+	}
+
+	for _, v := range patches {
+		if err = p.WriteRVA(v.rva, v.data); err != nil {
+			return err
+		}
+	}
+
+	if len(patches) > 0 {
+		h.setCheckSum(0)
+	}
+
+	return nil
+}
+
+// 5和7的含义和机器类型有关, 只支持ARM的MOV32.
+func isARM(machine uint16) bool {
+	return machine == pe.IMAGE_FILE_MACHINE_ARM || machine == pe.IMAGE_FILE_MACHINE_ARMNT || machine == pe.IMAGE_FILE_MACHINE_THUMB
+}
+
+// 不支持的类型返回0.
+func baseRelocationSize(machine uint16, typ uint8) uint32 {
+	switch typ {
+	case IMAGE_REL_BASED_HIGH, IMAGE_REL_BASED_LOW, IMAGE_REL_BASED_HIGHADJ:
+		return 2
+	case IMAGE_REL_BASED_HIGHLOW:
+		return 4
+	case IMAGE_REL_BASED_DIR64:
+		return 8
+	case IMAGE_REL_BASED_ARM_MOV32, IMAGE_REL_BASED_THUMB_MOV32:
+		if isARM(machine) {
+			return 8
+		}
+	}
+
+	return 0
+}
+
+// data是e.RVA处baseRelocationSize(machine, e.Type)大小的数据.
+func applyBaseRelocation(machine uint16, data []byte, e BaseRelocation, delta uint64) error {
+	le := binary.LittleEndian
+
+	if baseRelocationSize(machine, e.Type) == 0 {
+		return fmt.Errorf("pefile: unsupported base relocation %v at %#x", BaseRelocationTypeName(machine, e.Type), e.RVA)
+	}
+
+	switch e.Type {
+	case IMAGE_REL_BASED_HIGH:
+		le.PutUint16(data, le.Uint16(data)+uint16(uint32(delta)>>16))
+	case IMAGE_REL_BASED_LOW:
+		le.PutUint16(data, le.Uint16(data)+uint16(delta))
+	case IMAGE_REL_BASED_HIGHADJ:
+		v := uint32(le.Uint16(data))<<16 + uint32(int32(int16(e.Param)))
+		v += uint32(delta) + 0x8000
+		le.PutUint16(data, uint16(v>>16))
+	case IMAGE_REL_BASED_HIGHLOW:
+		le.PutUint32(data, le.Uint32(data)+uint32(delta))
+	case IMAGE_REL_BASED_DIR64:
+		le.PutUint64(data, le.Uint64(data)+delta)
+	case IMAGE_REL_BASED_ARM_MOV32:
+		movw, movt := le.Uint32(data), le.Uint32(data[4:])
+		v := armImm16(movt)<<16 | armImm16(movw)
+		v += uint32(delta)
+		le.PutUint32(data, setArmImm16(movw, uint16(v)))
+		le.PutUint32(data[4:], setArmImm16(movt, uint16(v>>16)))
+	case IMAGE_REL_BASED_THUMB_MOV32:
+		movw := uint32(le.Uint16(data))<<16 | uint32(le.Uint16(data[2:]))
+		movt := uint32(le.Uint16(data[4:]))<<16 | uint32(le.Uint16(data[6:]))
+		v := thumbImm16(movt)<<16 | thumbImm16(movw)
+		v += uint32(delta)
+		movw = setThumbImm16(movw, uint16(v))
+		movt = setThumbImm16(movt, uint16(v>>16))
+		le.PutUint16(data, uint16(movw>>16))
+		le.PutUint16(data[2:], uint16(movw))
+		le.PutUint16(data[4:], uint16(movt>>16))
+		le.PutUint16(data[6:], uint16(movt))
+	default:
+		return fmt.Errorf("pefile: unsupported base relocation type %d at %#x", e.Type, e.RVA)
+	}
+
+	return nil
+}
+
+// ARM MOVW/MOVT(A1): imm4在19:16, imm12在11:0.
+func armImm16(inst uint32) uint32 {
+	return (inst>>4)&0xf000 | inst&0xfff
+}
+
+func setArmImm16(inst uint32, imm uint16) uint32 {
+	return inst&^0xf0fff | uint32(imm&0xf000)<<4 | uint32(imm&0xfff)
+}
+
+// Thumb MOVW/MOVT(T3), 第一个半字在高16位: imm4在19:16, i在26, imm3在14:12, imm8在7:0.
+func thumbImm16(inst uint32) uint32 {
+	return (inst>>4)&0xf000 | (inst>>15)&0x800 | (inst>>4)&0x700 | inst&0xff
+}
+
+func setThumbImm16(inst uint32, imm uint16) uint32 {
+	v := uint32(imm)
+	return inst&^0x040f70ff | (v&0xf000)<<4 | (v&0x800)<<15 | (v&0x700)<<4 | v&0xff
+}
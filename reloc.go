@@ -0,0 +1,374 @@
+package pefile
+
+import (
+	"debug/pe"
+	"encoding/binary"
+	"fmt"
+	"sort"
+)
+
+// debug/pe在go1.19之后才有这几个常量
+const (
+	imageFileMachineLoongArch32 = 0x6232
+	imageFileMachineLoongArch64 = 0x6264
+	imageFileMachineRiscV32     = 0x5032
+	imageFileMachineRiscV64     = 0x5064
+	imageFileMachineRiscV128    = 0x5128
+)
+
+const baseRelocationPageSize = 0x1000
+
+type BaseRelocation struct {
+	Type   uint8
+	Offset uint16 //页内偏移
+	RVA    uint32
+	Param  uint16 //IMAGE_REL_BASED_HIGHADJ的第二项, 保存地址的低16位
+}
+
+type BaseRelocationBlock struct {
+	PageRVA     uint32
+	SizeOfBlock uint32
+	Entries     []BaseRelocation //不包括用来填充的IMAGE_REL_BASED_ABSOLUTE
+}
+
+type BaseRelocationIterator struct {
+	machine uint16
+	rva     uint32 //重定位目录的rva
+	data    []byte
+	pos     uint32
+	block   *BaseRelocationBlock
+	err     error
+}
+
+// 遍历重定位目录中的块:
+//
+//	it := f.BaseRelocations()
+//	for it.Next() {
+//		b := it.Block()
+//	}
+//	err := it.Err()
+func (p *PeFile) BaseRelocations() *BaseRelocationIterator {
+	it := &BaseRelocationIterator{machine: p.File.Machine}
+	if h := p.OptionHeaderView(); h != nil {
+		dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC)
+		if dir.VirtualAddress != 0 && dir.Size > 0 {
+			it.rva = dir.VirtualAddress
+			it.data, it.err = p.ReadRVA(dir.VirtualAddress, dir.Size)
+		}
+	}
+
+	return it
+}
+
+func (it *BaseRelocationIterator) Next() bool {
+	it.block = nil
+	if it.err != nil || it.pos >= uint32(len(it.data)) {
+		return false
+	}
+
+	left := uint32(len(it.data)) - it.pos
+	if left < ImageBaseRelocationSize {
+		if isZero(it.data[it.pos:]) { //目录的结尾可能有填充
+			return false
+		}
+
+		return it.fail("truncated block header")
+	}
+
+	data := it.data[it.pos:]
+	b := &BaseRelocationBlock{
+		PageRVA:     binary.LittleEndian.Uint32(data),
+		SizeOfBlock: binary.LittleEndian.Uint32(data[4:]),
+	}
+
+	if b.PageRVA == 0 && b.SizeOfBlock == 0 {
+		if isZero(data) {
+			return false
+		}
+
+		return it.fail("empty block")
+	}
+
+	if b.SizeOfBlock < ImageBaseRelocationSize {
+		return it.fail(fmt.Sprintf("SizeOfBlock %d too small", b.SizeOfBlock))
+	} else if b.SizeOfBlock > left {
+		return it.fail(fmt.Sprintf("SizeOfBlock %d overruns directory", b.SizeOfBlock))
+	} else if b.SizeOfBlock%4 != 0 {
+		return it.fail(fmt.Sprintf("SizeOfBlock %d not 32-bit aligned", b.SizeOfBlock))
+	} else if b.PageRVA%baseRelocationPageSize != 0 {
+		return it.fail(fmt.Sprintf("page rva %#x not page aligned", b.PageRVA))
+	}
+
+	entries := data[ImageBaseRelocationSize:b.SizeOfBlock]
+	for i := 0; i < len(entries); i += 2 {
+		v := binary.LittleEndian.Uint16(entries[i:])
+		e := BaseRelocation{Type: uint8(v >> 12), Offset: v & 0xfff}
+		e.RVA = b.PageRVA + uint32(e.Offset)
+
+		if e.Type == IMAGE_REL_BASED_ABSOLUTE {
+			continue
+		}
+
+		if !BaseRelocationTypeValid(it.machine, e.Type) {
+			return it.fail(fmt.Sprintf("type %d invalid for machine %#x at %#x", e.Type, it.machine, e.RVA))
+		}
+
+		if e.Type == IMAGE_REL_BASED_HIGHADJ {
+			if i += 2; i >= len(entries) {
+				return it.fail(fmt.Sprintf("HIGHADJ at %#x without parameter", e.RVA))
+			}
+			e.Param = binary.LittleEndian.Uint16(entries[i:])
+		}
+
+		b.Entries = append(b.Entries, e)
+	}
+
+	it.pos += b.SizeOfBlock
+	it.block = b
+	return true
+}
+
+func (it *BaseRelocationIterator) fail(reason string) bool {
+	it.err = fmt.Errorf("pefile: invalid base relocation block at %#x: %s", it.rva+it.pos, reason)
+	return false
+}
+
+func (it *BaseRelocationIterator) Block() *BaseRelocationBlock {
+	return it.block
+}
+
+func (it *BaseRelocationIterator) Err() error {
+	return it.err
+}
+
+// 读取全部重定位块.
+func (p *PeFile) ReadBaseRelocations() ([]*BaseRelocationBlock, error) {
+	var ret []*BaseRelocationBlock
+
+	it := p.BaseRelocations()
+	for it.Next() {
+		ret = append(ret, it.Block())
+	}
+
+	return ret, it.Err()
+}
+
+func BaseRelocationTypeValid(machine uint16, typ uint8) bool {
+	switch typ {
+	case IMAGE_REL_BASED_ABSOLUTE, IMAGE_REL_BASED_HIGH, IMAGE_REL_BASED_LOW,
+		IMAGE_REL_BASED_HIGHLOW, IMAGE_REL_BASED_HIGHADJ, IMAGE_REL_BASED_DIR64:
+		return true
+	}
+
+	return BaseRelocationTypeName(machine, typ) != ""
+}
+
+// 返回和机器类型相关的重定位类型名, 未知类型返回空串.
+func BaseRelocationTypeName(machine uint16, typ uint8) string {
+	switch typ {
+	case IMAGE_REL_BASED_ABSOLUTE:
+		return "ABSOLUTE"
+	case IMAGE_REL_BASED_HIGH:
+		return "HIGH"
+	case IMAGE_REL_BASED_LOW:
+		return "LOW"
+	case IMAGE_REL_BASED_HIGHLOW:
+		return "HIGHLOW"
+	case IMAGE_REL_BASED_HIGHADJ:
+		return "HIGHADJ"
+	case IMAGE_REL_BASED_DIR64:
+		return "DIR64"
+	}
+
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_ARM, pe.IMAGE_FILE_MACHINE_THUMB, pe.IMAGE_FILE_MACHINE_ARMNT:
+		switch typ {
+		case IMAGE_REL_BASED_ARM_MOV32:
+			return "ARM_MOV32"
+		case IMAGE_REL_BASED_THUMB_MOV32:
+			return "THUMB_MOV32"
+		}
+	case imageFileMachineRiscV32, imageFileMachineRiscV64, imageFileMachineRiscV128:
+		switch typ {
+		case IMAGE_REL_BASED_RISCV_HIGH20:
+			return "RISCV_HIGH20"
+		case IMAGE_REL_BASED_RISCV_LOW12I:
+			return "RISCV_LOW12I"
+		case IMAGE_REL_BASED_RISCV_LOW12S:
+			return "RISCV_LOW12S"
+		}
+	case imageFileMachineLoongArch32:
+		if typ == IMAGE_REL_BASED_LOONGARCH32_MARK_LA {
+			return "LOONGARCH32_MARK_LA"
+		}
+	case imageFileMachineLoongArch64:
+		if typ == IMAGE_REL_BASED_LOONGARCH64_MARK_LA {
+			return "LOONGARCH64_MARK_LA"
+		}
+	case pe.IMAGE_FILE_MACHINE_R4000, pe.IMAGE_FILE_MACHINE_WCEMIPSV2, pe.IMAGE_FILE_MACHINE_MIPSFPU:
+		if typ == IMAGE_REL_BASED_MIPS_JMPADDR {
+			return "MIPS_JMPADDR"
+		}
+	case pe.IMAGE_FILE_MACHINE_MIPS16, pe.IMAGE_FILE_MACHINE_MIPSFPU16:
+		switch typ {
+		case IMAGE_REL_BASED_MIPS_JMPADDR:
+			return "MIPS_JMPADDR"
+		case IMAGE_REL_BASED_MIPS_JMPADDR16:
+			return "MIPS_JMPADDR16"
+		}
+	case pe.IMAGE_FILE_MACHINE_IA64:
+		if typ == IMAGE_REL_BASED_IA64_IMM64 {
+			return "IA64_IMM64"
+		}
+	}
+
+	return ""
+}
+
+func isZero(data []byte) bool {
+	for _, v := range data {
+		if v != 0 {
+			return false
+		}
+	}
+
+	return true
+}
+
+type BaseRelocationBuilder struct {
+	p       *PeFile
+	entries map[uint32]BaseRelocation
+}
+
+// 生成新的重定位目录, 包含原有的重定位项.
+func (p *PeFile) NewBaseRelocationBuilder() (*BaseRelocationBuilder, error) {
+	if p.OptionHeaderView() == nil {
+		return nil, ErrNoOptionHeader
+	}
+
+	b := &BaseRelocationBuilder{p, make(map[uint32]BaseRelocation)}
+	it := p.BaseRelocations()
+	for it.Next() {
+		for _, e := range it.Block().Entries {
+			b.entries[e.RVA] = e
+		}
+	}
+
+	return b, it.Err()
+}
+
+// 同一个rva只保留最后添加的一项.
+func (b *BaseRelocationBuilder) Add(rva uint32, typ uint8) error {
+	if typ == IMAGE_REL_BASED_ABSOLUTE || typ == IMAGE_REL_BASED_HIGHADJ || !BaseRelocationTypeValid(b.p.File.Machine, typ) {
+		return fmt.Errorf("pefile: can't add base relocation type %d", typ)
+	}
+
+	b.entries[rva] = BaseRelocation{Type: typ, Offset: uint16(rva % baseRelocationPageSize), RVA: rva}
+	return nil
+}
+
+// low是地址的低16位.
+func (b *BaseRelocationBuilder) AddHighAdj(rva uint32, low uint16) {
+	b.entries[rva] = BaseRelocation{IMAGE_REL_BASED_HIGHADJ, uint16(rva % baseRelocationPageSize), rva, low}
+}
+
+func (b *BaseRelocationBuilder) Remove(rva uint32) {
+	delete(b.entries, rva)
+}
+
+func (b *BaseRelocationBuilder) Len() int {
+	return len(b.entries)
+}
+
+// 按4K页生成重定位块, 每块的大小按4字节对齐.
+func (b *BaseRelocationBuilder) Bytes() []byte {
+	rvas := make([]uint32, 0, len(b.entries))
+	for rva := range b.entries {
+		rvas = append(rvas, rva)
+	}
+	sort.Slice(rvas, func(i, j int) bool { return rvas[i] < rvas[j] })
+
+	var ret []byte
+	var word [2]byte
+	start := 0
+	current := uint32(0)
+	for i, rva := range rvas {
+		page := rva &^ (baseRelocationPageSize - 1)
+		if i == 0 || page != current {
+			current = page
+			ret = padBaseRelocationBlock(ret, start)
+			start = len(ret)
+			ret = append(ret, make([]byte, ImageBaseRelocationSize)...)
+			binary.LittleEndian.PutUint32(ret[start:], page)
+		}
+
+		e := b.entries[rva]
+		binary.LittleEndian.PutUint16(word[:], uint16(e.Type)<<12|uint16(rva-page))
+		ret = append(ret, word[:]...)
+		if e.Type == IMAGE_REL_BASED_HIGHADJ {
+			binary.LittleEndian.PutUint16(word[:], e.Param)
+			ret = append(ret, word[:]...)
+		}
+	}
+
+	return padBaseRelocationBlock(ret, start)
+}
+
+func padBaseRelocationBlock(data []byte, start int) []byte {
+	if len(data) > start {
+		if (len(data)-start)%4 != 0 {
+			data = append(data, 0, 0) //IMAGE_REL_BASED_ABSOLUTE
+		}
+
+		binary.LittleEndian.PutUint32(data[start+4:], uint32(len(data)-start))
+	}
+
+	return data
+}
+
+// 写入.reloc节并修改重定位目录. 原来的重定位目录单独占一个节时, 放得下就直接替换.
+// 节中还有其它数据时保留原来的节, 新加一个.reloc节.
+func (b *BaseRelocationBuilder) Commit() error {
+	p := b.p
+	h := p.OptionHeaderView()
+	data := b.Bytes()
+
+	dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC)
+	va := dir.VirtualAddress
+	if !p.isDirectorySection(dir) {
+		va = 0
+	}
+
+	if len(data) == 0 {
+		return h.SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC, pe.DataDirectory{})
+	}
+
+	va = p.placeSection(va, ".reloc", IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_DISCARDABLE|IMAGE_SCN_MEM_READ,
+		func(uint32) []byte { return data })
+	p.File.Characteristics &^= IMAGE_FILE_RELOCS_STRIPPED
+
+	return h.SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC, pe.DataDirectory{VirtualAddress: va, Size: uint32(len(data))})
+}
+
+// 删除重定位目录, 映像只能加载到ImageBase. 重定位目录单独占最后一个节时删除这个节,
+// 否则只清除目录, 以免虚拟地址不连续或删除其它数据.
+func (p *PeFile) StripRelocations() error {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return ErrNoOptionHeader
+	}
+
+	if dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC); p.isDirectorySection(dir) && dir.VirtualAddress == p.lastSectionAddress() {
+		p.removeSection(func(_ string, cur uint32) bool { return cur == dir.VirtualAddress })
+	}
+
+	if err := h.SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC, pe.DataDirectory{}); err != nil {
+		return err
+	}
+
+	p.File.Characteristics |= IMAGE_FILE_RELOCS_STRIPPED
+	h.SetDllCharacteristics(h.DllCharacteristics() &^ IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE)
+	p.sectionChanged()
+
+	return nil
+}
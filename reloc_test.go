@@ -0,0 +1,386 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"io/ioutil"
+	"strings"
+	"testing"
+)
+
+func TestBaseRelocations(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	blocks, err := f.ReadBaseRelocations()
+	if err != nil || len(blocks) == 0 {
+		t.Fatalf("ReadBaseRelocations failed: %v", err)
+	}
+
+	base := f.OptionHeaderView().ImageBase()
+	end := base + uint64(f.OptionHeaderView().SizeOfImage())
+	for _, b := range blocks {
+		for _, e := range b.Entries {
+			if e.Type != IMAGE_REL_BASED_HIGHLOW {
+				t.Fatalf("unexpected relocation type %v", BaseRelocationTypeName(f.File.Machine, e.Type))
+			}
+
+			data, err := f.ReadRVA(e.RVA, 4)
+			if err != nil {
+				t.Fatalf("ReadRVA %x failed: %v", e.RVA, err)
+			}
+
+			if v := uint64(binary.LittleEndian.Uint32(data)); v < base || v >= end {
+				t.Fatalf("relocated value %x at %x out of image", v, e.RVA)
+			}
+		}
+	}
+
+	it := &BaseRelocationIterator{machine: 0x14c, data: []byte{0, 0x10, 0, 0, 0x20, 0, 0, 0}}
+	if it.Next() || it.Err() == nil || !strings.Contains(it.Err().Error(), "overruns") {
+		t.Fatalf("overrun block should fail: %v", it.Err())
+	}
+
+	it = &BaseRelocationIterator{machine: 0x14c, data: []byte{0, 0x10, 0, 0, 10, 0, 0, 0, 0, 0x30, 0, 0}}
+	if it.Next() || it.Err() == nil || !strings.Contains(it.Err().Error(), "aligned") {
+		t.Fatalf("misaligned block should fail: %v", it.Err())
+	}
+
+	it = &BaseRelocationIterator{machine: 0x14c, data: []byte{0, 0x10, 0, 0, 12, 0, 0, 0, 0x10, 0x40, 0x34, 0x12}}
+	if !it.Next() || it.Block().Entries[0].Param != 0x1234 || it.Block().Entries[0].RVA != 0x1010 {
+		t.Fatalf("HIGHADJ decode failed: %v", it.Err())
+	}
+
+	it = &BaseRelocationIterator{machine: 0x8664, data: []byte{0, 0x10, 0, 0, 12, 0, 0, 0, 0x10, 0x50, 0, 0}}
+	if it.Next() || it.Err() == nil {
+		t.Fatalf("type 5 should be invalid for AMD64")
+	}
+
+	it = &BaseRelocationIterator{machine: imageFileMachineRiscV64, data: []byte{0, 0x10, 0, 0, 12, 0, 0, 0, 0x10, 0x50, 0, 0}}
+	if !it.Next() || BaseRelocationTypeName(imageFileMachineRiscV64, it.Block().Entries[0].Type) != "RISCV_HIGH20" {
+		t.Fatalf("RISCV_HIGH20 decode failed: %v", it.Err())
+	}
+}
+
+func TestRebase(t *testing.T) {
+	data, err := ioutil.ReadFile("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("read failed: %v", err)
+	}
+
+	f, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("New failed: %v", err)
+	}
+
+	blocks, _ := f.ReadBaseRelocations()
+	old := make(map[uint32]uint32)
+	for _, b := range blocks {
+		for _, e := range b.Entries {
+			v, _ := f.ReadRVA(e.RVA, 4)
+			old[e.RVA] = binary.LittleEndian.Uint32(v)
+		}
+	}
+
+	if err = f.Rebase(0x10000000); err != nil {
+		t.Fatalf("Rebase failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	n, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if n.OptionHeaderView().ImageBase() != 0x10000000 {
+		t.Fatalf("ImageBase not changed")
+	}
+
+	for rva, v := range old {
+		cur, _ := n.ReadRVA(rva, 4)
+		if binary.LittleEndian.Uint32(cur) != v-0x400000+0x10000000 {
+			t.Fatalf("relocation at %x not applied", rva)
+		}
+	}
+
+	if err = n.Rebase(0x400000); err != nil {
+		t.Fatalf("Rebase back failed: %v", err)
+	}
+
+	buf.Reset()
+	if err = n.WriteTo(&buf); err != nil || bytes.Compare(buf.Bytes(), data) != 0 {
+		t.Fatalf("Rebase back should restore the original image: %v", err)
+	}
+}
+
+// 5在MIPS上是JMPADDR, 不能按ARM的MOV32处理.
+func TestRebaseUnsupported(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	dir := f.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC)
+	entry, _ := f.ReadRVA(dir.VirtualAddress+8, 2)
+	binary.LittleEndian.PutUint16(entry, binary.LittleEndian.Uint16(entry)&0xfff|IMAGE_REL_BASED_MIPS_JMPADDR<<12)
+	if err = f.WriteRVA(dir.VirtualAddress+8, entry); err != nil {
+		t.Fatalf("WriteRVA failed: %v", err)
+	}
+
+	s := f.File.Section(".text")
+	text, _ := f.ReadRVA(s.VirtualAddress, s.VirtualSize)
+	f.File.Machine = pe.IMAGE_FILE_MACHINE_R4000
+	if err = f.Rebase(0x10000000); err == nil || !strings.Contains(err.Error(), "unsupported base relocation MIPS_JMPADDR") {
+		t.Fatalf("expect unsupported base relocation, got %v", err)
+	}
+
+	if data, _ := f.ReadRVA(s.VirtualAddress, s.VirtualSize); !bytes.Equal(data, text) || f.OptionHeaderView().ImageBase() != 0x400000 {
+		t.Fatalf("failed Rebase should not change the image")
+	}
+
+	if err = applyBaseRelocation(imageFileMachineRiscV32, make([]byte, 8), BaseRelocation{Type: IMAGE_REL_BASED_RISCV_HIGH20}, 0x10000); err == nil {
+		t.Fatalf("RISCV_HIGH20 should not be applied as ARM_MOV32")
+	}
+}
+
+func TestApplyBaseRelocation(t *testing.T) {
+	data := []byte{0x12, 0x00}
+	if err := applyBaseRelocation(pe.IMAGE_FILE_MACHINE_I386, data, BaseRelocation{Type: IMAGE_REL_BASED_HIGHADJ, Param: 0x9000}, 0x18000); err != nil {
+		t.Fatalf("HIGHADJ failed: %v", err)
+	} else if v := binary.LittleEndian.Uint16(data); v != 0x13 { //低16位有符号: 0x00119000 + 0x18000 + 0x8000 = 0x00139000
+		t.Fatalf("HIGHADJ should: 0x13, get: %x", v)
+	}
+
+	//movw r0, #0x5678; movt r0, #0x1234
+	thumb := []byte{0x45, 0xf2, 0x78, 0x60, 0xc1, 0xf2, 0x34, 0x20}
+	if err := applyBaseRelocation(pe.IMAGE_FILE_MACHINE_ARMNT, thumb, BaseRelocation{Type: IMAGE_REL_BASED_THUMB_MOV32}, 0x10010000); err != nil {
+		t.Fatalf("THUMB_MOV32 failed: %v", err)
+	}
+
+	movw := uint32(binary.LittleEndian.Uint16(thumb))<<16 | uint32(binary.LittleEndian.Uint16(thumb[2:]))
+	movt := uint32(binary.LittleEndian.Uint16(thumb[4:]))<<16 | uint32(binary.LittleEndian.Uint16(thumb[6:]))
+	if thumbImm16(movw) != 0x5678 || thumbImm16(movt) != 0x2235 {
+		t.Fatalf("THUMB_MOV32 result error: %x %x", thumbImm16(movt), thumbImm16(movw))
+	}
+
+	//movw r0, #0x5678; movt r0, #0x1234
+	arm := []byte{0x78, 0x06, 0x05, 0xe3, 0x34, 0x02, 0x41, 0xe3}
+	if err := applyBaseRelocation(pe.IMAGE_FILE_MACHINE_ARM, arm, BaseRelocation{Type: IMAGE_REL_BASED_ARM_MOV32}, 0xffff0000); err != nil {
+		t.Fatalf("ARM_MOV32 failed: %v", err)
+	}
+
+	if armImm16(binary.LittleEndian.Uint32(arm)) != 0x5678 || armImm16(binary.LittleEndian.Uint32(arm[4:])) != 0x1233 {
+		t.Fatalf("ARM_MOV32 result error")
+	}
+}
+
+func TestBaseRelocationBuilder(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	old, _ := f.ReadBaseRelocations()
+	code := make([]byte, 0x20)
+	binary.LittleEndian.PutUint32(code[4:], 0x401000)
+	f.AddSection(".patch", code, IMAGE_SCN_CNT_CODE|IMAGE_SCN_MEM_EXECUTE|IMAGE_SCN_MEM_READ)
+	va := f.mySections[0].virtualAddress
+
+	b, err := f.NewBaseRelocationBuilder()
+	if err != nil {
+		t.Fatalf("NewBaseRelocationBuilder failed: %v", err)
+	}
+
+	count := b.Len()
+	if err = b.Add(va+4, IMAGE_REL_BASED_HIGHLOW); err != nil {
+		t.Fatalf("Add failed: %v", err)
+	}
+
+	if err = b.Add(va+8, IMAGE_REL_BASED_ABSOLUTE); err == nil {
+		t.Fatalf("Add ABSOLUTE should fail")
+	}
+
+	if err = b.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	n, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	blocks, err := n.ReadBaseRelocations()
+	if err != nil || len(blocks) != len(old)+1 {
+		t.Fatalf("relocation blocks should: %v, get: %v, %v", len(old)+1, len(blocks), err)
+	}
+
+	total := 0
+	for _, b := range blocks {
+		total += len(b.Entries)
+		if b.SizeOfBlock%4 != 0 {
+			t.Fatalf("block %x not aligned", b.PageRVA)
+		}
+	}
+
+	if total != count+1 {
+		t.Fatalf("relocation entries should: %v, get: %v", count+1, total)
+	}
+
+	if err = n.Rebase(0x800000); err != nil {
+		t.Fatalf("Rebase failed: %v", err)
+	}
+
+	if v, _ := n.ReadRVA(va+4, 4); binary.LittleEndian.Uint32(v) != 0x801000 {
+		t.Fatalf("added relocation not applied")
+	}
+
+	g, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer g.Close()
+
+	sections := len(g.File.Sections)
+	if b, err = g.NewBaseRelocationBuilder(); err != nil || b.Len() != 0 {
+		t.Fatalf("NewBaseRelocationBuilder failed: %v", err)
+	}
+
+	b.Add(0x3000, IMAGE_REL_BASED_DIR64)
+	if err = b.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	if len(g.mySections) != 1 || g.mySections[0].name != ".reloc" || int(g.File.NumberOfSections) != sections+1 ||
+		g.File.Characteristics&IMAGE_FILE_RELOCS_STRIPPED != 0 {
+		t.Fatalf(".reloc section not added")
+	}
+
+	if blocks, err = g.ReadBaseRelocations(); err != nil || len(blocks) != 1 || blocks[0].Entries[0].RVA != 0x3000 {
+		t.Fatalf("relocation error: %v", err)
+	}
+}
+
+// 重定位目录所在的节中还有其它数据时, 不能替换这个节.
+func TestBaseRelocationBuilderSharedSection(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	dir := f.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC)
+	relocs, _ := f.ReadRVA(dir.VirtualAddress, dir.Size)
+	f.AddSection(".mixed", append(relocs, "keep me"...), IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+	va := f.mySections[0].virtualAddress
+	f.OptionHeaderView().SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC, pe.DataDirectory{VirtualAddress: va, Size: dir.Size})
+
+	b, err := f.NewBaseRelocationBuilder()
+	if err != nil {
+		t.Fatalf("NewBaseRelocationBuilder failed: %v", err)
+	}
+
+	if err = b.Commit(); err != nil {
+		t.Fatalf("Commit failed: %v", err)
+	}
+
+	if d := f.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC); d.VirtualAddress == va || len(f.mySections) != 2 {
+		t.Fatalf("shared section should not be replaced: %+v", d)
+	}
+
+	if data, _ := f.ReadRVA(va+dir.Size, 7); string(data) != "keep me" {
+		t.Fatalf("section data lost: %q", data)
+	}
+}
+
+func TestStripRelocations(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	data := f.OptionHeader().SizeOfInitializedData
+	size := f.OptionHeader().SizeOfImage - f.alignSize(virtualSize(f.File.Section(".reloc")), false)
+	if err = f.StripRelocations(); err != nil {
+		t.Fatalf("StripRelocations failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	n, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	h := n.OptionHeader()
+	if n.File.Section(".reloc") != nil || len(n.File.Sections) != 3 {
+		t.Fatalf(".reloc section should be removed")
+	}
+
+	if h.DataDirectory[pe.IMAGE_DIRECTORY_ENTRY_BASERELOC] != (pe.DataDirectory{}) ||
+		n.File.Characteristics&IMAGE_FILE_RELOCS_STRIPPED == 0 ||
+		h.DllCharacteristics&IMAGE_DLLCHARACTERISTICS_DYNAMIC_BASE != 0 {
+		t.Fatalf("relocation flags error")
+	}
+
+	initialized := uint32(0)
+	for _, s := range n.File.Sections {
+		if s.Characteristics&IMAGE_SCN_CNT_INITIALIZED_DATA != 0 {
+			initialized += n.alignSize(s.Size, true)
+		}
+	}
+
+	if h.SizeOfImage != size || h.SizeOfInitializedData != initialized || h.SizeOfInitializedData >= data {
+		t.Fatalf("SizeOfImage: %x, SizeOfInitializedData: %x", h.SizeOfImage, h.SizeOfInitializedData)
+	}
+
+	if err = n.Rebase(0x10000000); err != ErrRelocsStripped {
+		t.Fatalf("Rebase should fail: %v", err)
+	}
+
+	//重定位目录所在的节还有其它数据, 或者不是最后一个节时只清除目录
+	for i, change := range []func(g *PeFile, dir pe.DataDirectory){
+		func(g *PeFile, dir pe.DataDirectory) {
+			dir.Size -= 4
+			g.OptionHeaderView().SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC, dir)
+		},
+		func(g *PeFile, dir pe.DataDirectory) {
+			g.AddSection(".test", []byte("after"), IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+		},
+	} {
+		g, err := Open("testdata/hello_vc_exe")
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer g.Close()
+
+		change(g, g.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC))
+		size = g.OptionHeader().SizeOfImage
+		if err = g.StripRelocations(); err != nil {
+			t.Fatalf("StripRelocations failed: %v", err)
+		}
+
+		if g.File.Section(".reloc") == nil || g.OptionHeader().SizeOfImage != size ||
+			g.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_BASERELOC) != (pe.DataDirectory{}) {
+			t.Fatalf("case %v: .reloc section should be kept", i)
+		}
+	}
+}
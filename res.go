@@ -0,0 +1,256 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+)
+
+var ErrInvalidResFile = errors.New("pefile: invalid .res file")
+
+const (
+	resHeaderMin   = 32
+	resDefaultFlag = 0x1030 //MOVEABLE|PURE|DISCARDABLE
+)
+
+// .res文件中的一个资源.
+type ResFileEntry struct {
+	Type, Name      ResourceID
+	Lang            uint16
+	MemoryFlags     uint16
+	DataVersion     uint32
+	Version         uint32
+	Characteristics uint32
+	Data            []byte
+}
+
+// 解析rc.exe或windres生成的.res文件, 跳过开头的空资源.
+func ParseResFile(data []byte) ([]*ResFileEntry, error) {
+	var ret []*ResFileEntry
+	le := binary.LittleEndian
+	for pos := 0; pos < len(data); {
+		if pos+8 > len(data) {
+			return nil, ErrInvalidResFile
+		}
+
+		dataSize, headerSize := int(le.Uint32(data[pos:])), int(le.Uint32(data[pos+4:]))
+		if headerSize < resHeaderMin || pos+headerSize > len(data) || pos+headerSize+dataSize > len(data) || dataSize < 0 {
+			return nil, ErrInvalidResFile
+		}
+
+		header := data[pos : pos+headerSize]
+		e := &ResFileEntry{}
+		cur, ok := 8, false
+		if e.Type, cur, ok = readResID(header, cur); !ok {
+			return nil, ErrInvalidResFile
+		}
+
+		if e.Name, cur, ok = readResID(header, cur); !ok {
+			return nil, ErrInvalidResFile
+		}
+
+		cur = int(alignUp(uint32(cur), 4))
+		if cur+16 > len(header) {
+			return nil, ErrInvalidResFile
+		}
+
+		e.DataVersion = le.Uint32(header[cur:])
+		e.MemoryFlags = le.Uint16(header[cur+4:])
+		e.Lang = le.Uint16(header[cur+6:])
+		e.Version = le.Uint32(header[cur+8:])
+		e.Characteristics = le.Uint32(header[cur+12:])
+		e.Data = data[pos+headerSize : pos+headerSize+dataSize]
+
+		if e.Type != ResID(0) {
+			ret = append(ret, e)
+		}
+		pos = int(alignUp(uint32(pos+headerSize+dataSize), 4))
+	}
+
+	return ret, nil
+}
+
+// 0xffff开头为ID, 否则为0结尾的UTF-16字符串.
+func readResID(data []byte, pos int) (ResourceID, int, bool) {
+	le := binary.LittleEndian
+	if pos+2 > len(data) {
+		return ResourceID{}, 0, false
+	}
+
+	if le.Uint16(data[pos:]) == 0xffff {
+		if pos+4 > len(data) {
+			return ResourceID{}, 0, false
+		}
+
+		return ResID(uint32(le.Uint16(data[pos+2:]))), pos + 4, true
+	}
+
+	for end := pos; end+2 <= len(data); end += 2 {
+		if le.Uint16(data[end:]) == 0 {
+			return ResName(decodeUTF16(data[pos:end])), end + 2, true
+		}
+	}
+
+	return ResourceID{}, 0, false
+}
+
+func writeResID(buf *bytes.Buffer, id ResourceID) {
+	if id.IsNamed() {
+		buf.Write(encodeUTF16(id.Name + "\x00"))
+	} else {
+		binary.Write(buf, binary.LittleEndian, [2]uint16{0xffff, uint16(id.ID)})
+	}
+}
+
+// 生成.res文件.
+func ResFileBytes(entries []*ResFileEntry) []byte {
+	var buf bytes.Buffer
+	empty := &ResFileEntry{}
+	for _, e := range append([]*ResFileEntry{empty}, entries...) {
+		var header bytes.Buffer
+		header.Write(make([]byte, 8))
+		writeResID(&header, e.Type)
+		writeResID(&header, e.Name)
+		header.Write(make([]byte, int(alignUp(uint32(header.Len()), 4))-header.Len()))
+		binary.Write(&header, binary.LittleEndian, struct {
+			DataVersion        uint32
+			MemoryFlags, Lang  uint16
+			Version, Character uint32
+		}{e.DataVersion, e.MemoryFlags, e.Lang, e.Version, e.Characteristics})
+
+		h := header.Bytes()
+		binary.LittleEndian.PutUint32(h, uint32(len(e.Data)))
+		binary.LittleEndian.PutUint32(h[4:], uint32(len(h)))
+		buf.Write(h)
+		buf.Write(e.Data)
+		buf.Write(make([]byte, int(alignUp(uint32(buf.Len()), 4))-buf.Len()))
+	}
+
+	return buf.Bytes()
+}
+
+// 把.res中的资源加入目录, 相同类型/名字/语言的资源被替换.
+func (d *ResourceDirectory) Merge(entries []*ResFileEntry) {
+	for _, e := range entries {
+		d.Set(e.Type, e.Name, e.Lang, e.Data)
+	}
+}
+
+// 合并.res文件并重新生成资源节.
+func (p *PeFile) AddResFile(data []byte) error {
+	entries, err := ParseResFile(data)
+	if err != nil {
+		return err
+	}
+
+	root, err := p.Resources()
+	if err != nil {
+		return err
+	} else if root == nil {
+		root = &ResourceDirectory{}
+	}
+
+	root.Merge(entries)
+	return p.SetResources(root)
+}
+
+// 把.res文件转换成COFF对象(和cvtres相同), 用WriteTo输出.
+// 目录放在.rsrc$01, 数据放在.rsrc$02, 数据项通过重定位指向.rsrc$02.
+func NewResourceObject(data []byte, machine uint16) (*PeFile, error) {
+	var relocType uint16
+	switch machine {
+	case pe.IMAGE_FILE_MACHINE_I386:
+		relocType = IMAGE_REL_I386_DIR32NB
+	case pe.IMAGE_FILE_MACHINE_AMD64:
+		relocType = IMAGE_REL_AMD64_ADDR32NB
+	case pe.IMAGE_FILE_MACHINE_ARMNT:
+		relocType = IMAGE_REL_ARM_ADDR32NB
+	case pe.IMAGE_FILE_MACHINE_ARM64:
+		relocType = IMAGE_REL_ARM64_ADDR32NB
+	default:
+		return nil, errors.New("pefile: unsupported machine for resource object")
+	}
+
+	entries, err := ParseResFile(data)
+	if err != nil {
+		return nil, err
+	}
+
+	root := &ResourceDirectory{}
+	root.Merge(entries)
+	raw, dirSize := buildResources(root, 0)
+
+	//数据项在全部目录之后
+	entryPos, count := resourceDirectoriesSize(root), 0
+	root.Walk(func(_ []ResourceID, _ *ResourceData) error {
+		count++
+		return nil
+	})
+
+	dataStart := alignUp(dirSize, 8)
+	var relocs []pe.Reloc
+	for i := 0; i < count; i++ {
+		pos := entryPos + uint32(i)*ImageResourceDataEntrySize
+		offset := binary.LittleEndian.Uint32(raw[pos:])
+		binary.LittleEndian.PutUint32(raw[pos:], offset-dataStart)
+		relocs = append(relocs, pe.Reloc{VirtualAddress: pos, Type: relocType})
+	}
+
+	var characteristics uint16
+	if machine == pe.IMAGE_FILE_MACHINE_I386 {
+		characteristics = IMAGE_FILE_32BIT_MACHINE
+	}
+
+	//COFF对象没有OptionalHeader, 节和符号表都是新加的
+	p := &PeFile{File: &pe.File{FileHeader: pe.FileHeader{Machine: machine, Characteristics: characteristics}},
+		sectionAlignment: 1, fileAlignment: 1}
+
+	ch := uint32(IMAGE_SCN_CNT_INITIALIZED_DATA | IMAGE_SCN_MEM_READ | IMAGE_SCN_MEM_WRITE)
+	p.AddSection(".rsrc$01", raw[:dirSize], ch)
+	p.AddSection(".rsrc$02", raw[dataStart:], ch)
+	p.mySections[0].relocs = relocs
+
+	var symbols bytes.Buffer
+	if machine == pe.IMAGE_FILE_MACHINE_I386 { //@feat.00表示支持SafeSEH
+		writeCOFFSymbol(&symbols, "@feat.00", 1, IMAGE_SYM_ABSOLUTE, 0)
+	}
+
+	for i, s := range p.mySections {
+		writeCOFFSymbol(&symbols, s.name, 0, int16(i+1), 1)
+		binary.Write(&symbols, binary.LittleEndian, struct { //节定义的辅助符号
+			Length              uint32
+			NumberOfRelocations uint16
+			Unused              [12]byte
+		}{uint32(len(s.data)), uint16(len(s.relocs)), [12]byte{}})
+	}
+
+	//重定位指向.rsrc$02的节符号
+	for i := range relocs {
+		relocs[i].SymbolTableIndex = uint32(symbols.Len()/COFFSymbolSize - 2)
+	}
+	p.symbols = symbols.Bytes()
+	p.File.NumberOfSymbols = uint32(symbols.Len() / COFFSymbolSize)
+	p.File.StringTable = []byte{}
+
+	return p, nil
+}
+
+func writeCOFFSymbol(buf *bytes.Buffer, name string, value uint32, section int16, aux uint8) {
+	var n [8]byte
+	copy(n[:], name)
+	binary.Write(buf, binary.LittleEndian, &pe.COFFSymbol{Name: n, Value: value, SectionNumber: section,
+		StorageClass: IMAGE_SYM_CLASS_STATIC, NumberOfAuxSymbols: aux})
+}
+
+// 全部目录表的大小, 即第一个数据项的偏移.
+func resourceDirectoriesSize(d *ResourceDirectory) uint32 {
+	size := ImageResourceDirectorySize + uint32(len(d.Entries))*ImageResourceDirectoryEntrySize
+	for _, e := range d.Entries {
+		if e.Directory != nil {
+			size += resourceDirectoriesSize(e.Directory)
+		}
+	}
+
+	return uint32(size)
+}
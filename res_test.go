@@ -0,0 +1,147 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"testing"
+)
+
+func testResFile(t *testing.T) ([]*ResFileEntry, []byte) {
+	manifest, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer manifest.Close()
+
+	data, err := manifest.Manifest()
+	if err != nil {
+		t.Fatalf("Manifest failed: %v", err)
+	}
+
+	v := NewVersionInfo(0x409, 1200)
+	v.SetFileVersion(1, 0, 0, 0)
+	entries := []*ResFileEntry{
+		{Type: ResID(RT_MANIFEST), Name: ResID(1), Lang: 0x409, MemoryFlags: resDefaultFlag, Data: data},
+		{Type: ResID(RT_VERSION), Name: ResID(1), Lang: 0x409, MemoryFlags: resDefaultFlag, Data: v.Bytes()},
+		{Type: ResName("CONFIG"), Name: ResName("DEFAULT"), Lang: 0x804, MemoryFlags: resDefaultFlag, Data: []byte("odd")},
+	}
+
+	return entries, ResFileBytes(entries)
+}
+
+func TestResFile(t *testing.T) {
+	entries, res := testResFile(t)
+	parsed, err := ParseResFile(res)
+	if err != nil || len(parsed) != len(entries) {
+		t.Fatalf("ParseResFile failed: %v", err)
+	}
+
+	for i, e := range parsed {
+		if e.Type != entries[i].Type || e.Name != entries[i].Name || e.Lang != entries[i].Lang ||
+			e.MemoryFlags != resDefaultFlag || bytes.Compare(e.Data, entries[i].Data) != 0 {
+			t.Fatalf("entry %v error: %+v", i, e)
+		}
+	}
+
+	if bytes.Compare(ResFileBytes(parsed), res) != 0 {
+		t.Fatalf(".res round trip failed")
+	}
+
+	if _, err = ParseResFile(res[:len(res)-8]); err == nil {
+		t.Fatalf("ParseResFile should fail with truncated data")
+	}
+
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if err = f.AddResFile(res); err != nil {
+		t.Fatalf("AddResFile failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	g, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	root, err := g.Resources()
+	if err != nil || len(root.List()) != 3 {
+		t.Fatalf("Resources failed: %v", err)
+	}
+
+	if e := root.Get(ResName("CONFIG"), ResName("DEFAULT"), ResID(0x804)); e == nil {
+		t.Fatalf("named resource not found")
+	} else if data, _ := e.Data.Bytes(); string(data) != "odd" {
+		t.Fatalf("named resource data error: %q", data)
+	}
+
+	if v, err := g.VersionInfo(); err != nil || v == nil {
+		t.Fatalf("VersionInfo failed: %v", err)
+	}
+}
+
+func TestResourceObject(t *testing.T) {
+	entries, res := testResFile(t)
+	for _, machine := range []uint16{pe.IMAGE_FILE_MACHINE_I386, pe.IMAGE_FILE_MACHINE_AMD64} {
+		p, err := NewResourceObject(res, machine)
+		if err != nil {
+			t.Fatalf("NewResourceObject failed: %v", err)
+		}
+		defer p.Close()
+
+		if p.OptionHeaderView() != nil || p.Overlay() != nil || p.File.Machine != machine {
+			t.Fatalf("resource object header error")
+		}
+
+		var buf bytes.Buffer
+		if err = p.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+
+		f, err := pe.NewFile(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("pe.NewFile failed: %v", err)
+		}
+
+		dirs, datas := f.Section(".rsrc$01"), f.Section(".rsrc$02")
+		if f.Machine != machine || len(f.Sections) != 2 || dirs == nil || datas == nil || len(dirs.Relocs) != len(entries) {
+			t.Fatalf("object sections error")
+		}
+
+		target := f.COFFSymbols[dirs.Relocs[0].SymbolTableIndex]
+		if target.SectionNumber != 2 || target.StorageClass != IMAGE_SYM_CLASS_STATIC {
+			t.Fatalf("relocation symbol error: %+v", target)
+		}
+
+		if _, err := New(bytes.NewReader(buf.Bytes())); err != nil {
+			t.Fatalf("New failed: %v", err)
+		}
+
+		//按重定位把数据项指向.rsrc$02, 再和原始资源比较
+		dirData, _ := dirs.Data()
+		dataData, _ := datas.Data()
+		found := 0
+		for _, r := range dirs.Relocs {
+			offset := binary.LittleEndian.Uint32(dirData[r.VirtualAddress:])
+			size := binary.LittleEndian.Uint32(dirData[r.VirtualAddress+4:])
+			for _, e := range entries {
+				if bytes.Compare(dataData[offset:offset+size], e.Data) == 0 {
+					found++
+					break
+				}
+			}
+		}
+
+		if found != len(entries) {
+			t.Fatalf("resource data error")
+		}
+	}
+}
@@ -0,0 +1,579 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strconv"
+	"unicode/utf16"
+)
+
+var ErrInvalidResource = errors.New("pefile: invalid resource directory")
+
+const (
+	maxResourceDepth   = 16
+	maxResourceEntries = 0x20000 //子目录可以共用, 每次引用都要展开, 限制读取的项的总数
+)
+
+// 资源的名字, Name为空时使用ID.
+type ResourceID struct {
+	Name string
+	ID   uint32
+}
+
+func ResID(id uint32) ResourceID {
+	return ResourceID{ID: id}
+}
+
+func ResName(name string) ResourceID {
+	return ResourceID{Name: name}
+}
+
+func (id ResourceID) IsNamed() bool {
+	return id.Name != ""
+}
+
+func (id ResourceID) String() string {
+	if id.Name != "" {
+		return id.Name
+	}
+
+	return "#" + strconv.Itoa(int(id.ID))
+}
+
+type ResourceDirectory struct {
+	Characteristics uint32
+	TimeDateStamp   uint32
+	MajorVersion    uint16
+	MinorVersion    uint16
+	Entries         []*ResourceEntry
+}
+
+// Directory和Data只有一个不为nil.
+type ResourceEntry struct {
+	ResourceID
+	Directory *ResourceDirectory
+	Data      *ResourceData
+}
+
+type ResourceData struct {
+	RVA      uint32
+	Size     uint32
+	CodePage uint32
+	Reserved uint32
+	data     []byte //新设置的数据, 为nil时从映像中读取
+	p        *PeFile
+}
+
+func (d *ResourceData) Bytes() ([]byte, error) {
+	if d.data != nil || d.p == nil {
+		return d.data, nil
+	}
+
+	return d.p.ReadRVA(d.RVA, d.Size)
+}
+
+func (d *ResourceData) Open() (io.Reader, error) {
+	data, err := d.Bytes()
+	if err != nil {
+		return nil, err
+	}
+
+	return bytes.NewReader(data), nil
+}
+
+// 通常的三层结构: 类型/名字/语言.
+type Resource struct {
+	Type, Name ResourceID
+	Lang       uint16
+	Data       *ResourceData
+}
+
+// 没有资源时返回nil, nil.
+func (p *PeFile) Resources() (*ResourceDirectory, error) {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return nil, nil
+	}
+
+	dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_RESOURCE)
+	if dir.VirtualAddress == 0 || dir.Size == 0 {
+		return nil, nil
+	}
+
+	data, err := p.ReadRVA(dir.VirtualAddress, dir.Size)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &resourceReader{p: p, data: data, ancestors: make(map[uint32]bool)}
+	return r.readDirectory(0, 0)
+}
+
+type resourceReader struct {
+	p         *PeFile
+	data      []byte
+	ancestors map[uint32]bool //当前路径上的目录, 用于检查循环
+	count     int             //读取的项的总数
+}
+
+func (r *resourceReader) fail(offset uint32, reason string) error {
+	return fmt.Errorf("%v: %s at %#x", ErrInvalidResource, reason, offset)
+}
+
+func (r *resourceReader) readDirectory(offset uint32, depth int) (*ResourceDirectory, error) {
+	if depth > maxResourceDepth {
+		return nil, r.fail(offset, "too deep")
+	}
+
+	if r.ancestors[offset] {
+		return nil, r.fail(offset, "cyclic directory")
+	}
+
+	r.ancestors[offset] = true
+	defer delete(r.ancestors, offset)
+
+	if uint64(offset)+ImageResourceDirectorySize > uint64(len(r.data)) {
+		return nil, r.fail(offset, "directory out of bounds")
+	}
+
+	var h ImageResourceDirectory
+	binary.Read(bytes.NewReader(r.data[offset:]), binary.LittleEndian, &h)
+	count := uint32(h.NumberOfNamedEntries) + uint32(h.NumberOfIdEntries)
+	entries := offset + ImageResourceDirectorySize
+	if uint64(entries)+uint64(count)*ImageResourceDirectoryEntrySize > uint64(len(r.data)) {
+		return nil, r.fail(offset, "entries out of bounds")
+	}
+
+	if r.count += int(count); r.count > maxResourceEntries {
+		return nil, r.fail(offset, "too many entries")
+	}
+
+	ret := &ResourceDirectory{h.Characteristics, h.TimeDateStamp, h.MajorVersion, h.MinorVersion, nil}
+	for i := uint32(0); i < count; i++ {
+		cur := entries + i*ImageResourceDirectoryEntrySize
+		name := binary.LittleEndian.Uint32(r.data[cur:])
+		target := binary.LittleEndian.Uint32(r.data[cur+4:])
+
+		e := &ResourceEntry{}
+		if name&IMAGE_RESOURCE_NAME_IS_STRING != 0 {
+			var err error
+			if e.Name, err = r.readString(name &^ IMAGE_RESOURCE_NAME_IS_STRING); err != nil {
+				return nil, err
+			}
+		} else {
+			e.ID = name
+		}
+
+		var err error
+		if target&IMAGE_RESOURCE_DATA_IS_DIRECTORY != 0 {
+			e.Directory, err = r.readDirectory(target&^IMAGE_RESOURCE_DATA_IS_DIRECTORY, depth+1)
+		} else {
+			e.Data, err = r.readData(target)
+		}
+
+		if err != nil {
+			return nil, err
+		}
+		ret.Entries = append(ret.Entries, e)
+	}
+
+	return ret, nil
+}
+
+func (r *resourceReader) readString(offset uint32) (string, error) {
+	if uint64(offset)+2 > uint64(len(r.data)) {
+		return "", r.fail(offset, "name out of bounds")
+	}
+
+	size := uint32(binary.LittleEndian.Uint16(r.data[offset:]))
+	if uint64(offset)+2+uint64(size)*2 > uint64(len(r.data)) {
+		return "", r.fail(offset, "name out of bounds")
+	}
+
+	return decodeUTF16(r.data[offset+2 : offset+2+size*2]), nil
+}
+
+func (r *resourceReader) readData(offset uint32) (*ResourceData, error) {
+	if uint64(offset)+ImageResourceDataEntrySize > uint64(len(r.data)) {
+		return nil, r.fail(offset, "data entry out of bounds")
+	}
+
+	var e ImageResourceDataEntry
+	binary.Read(bytes.NewReader(r.data[offset:]), binary.LittleEndian, &e)
+	if uint64(e.OffsetToData)+uint64(e.Size) > uint64(r.p.OptionHeaderView().SizeOfImage()) {
+		return nil, r.fail(offset, "data out of image")
+	}
+
+	return &ResourceData{RVA: e.OffsetToData, Size: e.Size, CodePage: e.CodePage, Reserved: e.Reserved, p: r.p}, nil
+}
+
+func decodeUTF16(data []byte) string {
+	u := make([]uint16, len(data)/2)
+	for i := range u {
+		u[i] = binary.LittleEndian.Uint16(data[i*2:])
+	}
+
+	return string(utf16.Decode(u))
+}
+
+func (d *ResourceDirectory) Entry(id ResourceID) *ResourceEntry {
+	for _, e := range d.Entries {
+		if e.ResourceID == id {
+			return e
+		}
+	}
+
+	return nil
+}
+
+// 按路径查找, 例如Get(ResID(RT_MANIFEST), ResID(1), ResID(0x409)).
+func (d *ResourceDirectory) Get(path ...ResourceID) *ResourceEntry {
+	var e *ResourceEntry
+	for _, id := range path {
+		if d == nil {
+			return nil
+		}
+
+		if e = d.Entry(id); e == nil {
+			return nil
+		}
+		d = e.Directory
+	}
+
+	return e
+}
+
+// 深度优先遍历全部数据项, fn返回错误时停止.
+func (d *ResourceDirectory) Walk(fn func(path []ResourceID, data *ResourceData) error) error {
+	return d.walk(nil, fn)
+}
+
+func (d *ResourceDirectory) walk(path []ResourceID, fn func(path []ResourceID, data *ResourceData) error) error {
+	for _, e := range d.Entries {
+		cur := append(path[:len(path):len(path)], e.ResourceID)
+		var err error
+		if e.Directory != nil {
+			err = e.Directory.walk(cur, fn)
+		} else if e.Data != nil {
+			err = fn(cur, e.Data)
+		}
+
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 返回类型/名字/语言三层结构中的全部资源, 层次不符合的数据项被忽略.
+func (d *ResourceDirectory) List() []Resource {
+	var ret []Resource
+	d.Walk(func(path []ResourceID, data *ResourceData) error {
+		if len(path) == 3 && !path[2].IsNamed() {
+			ret = append(ret, Resource{path[0], path[1], uint16(path[2].ID), data})
+		}
+		return nil
+	})
+
+	return ret
+}
+
+// 查找指定类型和名字的资源, 返回所有语言的版本.
+func (d *ResourceDirectory) Find(typ, name ResourceID) []Resource {
+	var ret []Resource
+	for _, r := range d.List() {
+		if r.Type == typ && r.Name == name {
+			ret = append(ret, r)
+		}
+	}
+
+	return ret
+}
+
+// 添加或替换资源, 需要时创建中间的目录.
+func (d *ResourceDirectory) Set(typ, name ResourceID, lang uint16, data []byte) {
+	cur := d
+	for _, id := range []ResourceID{typ, name} {
+		e := cur.Entry(id)
+		if e == nil || e.Directory == nil {
+			if e == nil {
+				e = &ResourceEntry{ResourceID: id}
+				cur.Entries = append(cur.Entries, e)
+			}
+			e.Directory, e.Data = &ResourceDirectory{}, nil
+		}
+		cur = e.Directory
+	}
+
+	e := cur.Entry(ResID(uint32(lang)))
+	if e == nil {
+		e = &ResourceEntry{ResourceID: ResID(uint32(lang))}
+		cur.Entries = append(cur.Entries, e)
+	}
+
+	e.Directory = nil
+	e.Data = &ResourceData{Size: uint32(len(data)), data: append([]byte{}, data...)}
+}
+
+// 删除资源, 同时删除因此变空的目录.
+func (d *ResourceDirectory) Delete(typ, name ResourceID, lang uint16) bool {
+	return d.delete([]ResourceID{typ, name, ResID(uint32(lang))})
+}
+
+// 删除一个类型(或类型下的一个名字)的全部资源.
+func (d *ResourceDirectory) DeleteAll(path ...ResourceID) bool {
+	return d.delete(path)
+}
+
+func (d *ResourceDirectory) delete(path []ResourceID) bool {
+	for i, e := range d.Entries {
+		if e.ResourceID != path[0] {
+			continue
+		}
+
+		if len(path) > 1 {
+			if e.Directory == nil || !e.Directory.delete(path[1:]) {
+				return false
+			}
+
+			if len(e.Directory.Entries) > 0 {
+				return true
+			}
+		}
+
+		d.Entries = append(d.Entries[:i], d.Entries[i+1:]...)
+		return true
+	}
+
+	return false
+}
+
+// 重新生成资源节, 原来的资源目录单独占一个节并且放得下时原地替换, 否则新加一个.rsrc节.
+// 原来的节中还有其它数据时保留这个节.
+func (p *PeFile) SetResources(root *ResourceDirectory) error {
+	h := p.OptionHeaderView()
+	if h == nil {
+		return ErrNoOptionHeader
+	}
+
+	if err := root.check(nil); err != nil {
+		return err
+	}
+
+	//替换节之前先读出全部数据
+	err := root.Walk(func(_ []ResourceID, data *ResourceData) (err error) {
+		if data.data == nil {
+			if data.data, err = data.Bytes(); err == nil && data.data == nil {
+				data.data = []byte{}
+			}
+		}
+		return
+	})
+	if err != nil {
+		return err
+	}
+
+	dir := h.DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_RESOURCE)
+	va := dir.VirtualAddress
+	if !p.isDirectorySection(dir) { //节中还有其它数据时不能替换
+		va = 0
+	}
+
+	if len(root.Entries) == 0 {
+		return h.SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_RESOURCE, pe.DataDirectory{})
+	}
+
+	var size uint32
+	va = p.placeSection(va, ".rsrc", IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ, func(va uint32) []byte {
+		data, dirSize := buildResources(root, va)
+		size = dirSize
+		return data
+	})
+
+	root.Walk(func(_ []ResourceID, data *ResourceData) error {
+		data.p = p
+		return nil
+	})
+
+	return h.SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_RESOURCE, pe.DataDirectory{VirtualAddress: va, Size: size})
+}
+
+// 每一项必须有子目录或数据, 否则生成的偏移为0, 指向根目录.
+func (d *ResourceDirectory) check(path []ResourceID) error {
+	for _, e := range d.Entries {
+		cur := append(path[:len(path):len(path)], e.ResourceID)
+		if e.Directory != nil {
+			if err := e.Directory.check(cur); err != nil {
+				return err
+			}
+		} else if e.Data == nil {
+			return fmt.Errorf("%v: entry %v has neither directory nor data", ErrInvalidResource, cur)
+		}
+	}
+
+	return nil
+}
+
+// 生成资源节的数据: 目录表, 数据项, 名字, 按8字节对齐的数据. 返回的dirSize不包括资源数据.
+func buildResources(root *ResourceDirectory, va uint32) (ret []byte, dirSize uint32) {
+	type dirItem struct {
+		dir     *ResourceDirectory
+		entries []*ResourceEntry
+		offset  uint32
+	}
+
+	var dirs []*dirItem
+	var datas []*ResourceData
+	var names []string
+	dirOffset := make(map[*ResourceDirectory]uint32)
+	dataOffset := make(map[*ResourceData]uint32)
+	nameOffset := make(map[string]uint32)
+
+	//按层次顺序排列目录
+	size := uint32(0)
+	queue := []*ResourceDirectory{root}
+	for len(queue) > 0 {
+		d := queue[0]
+		queue = queue[1:]
+
+		item := &dirItem{d, sortResourceEntries(d.Entries), size}
+		dirs = append(dirs, item)
+		dirOffset[d] = size
+		size += ImageResourceDirectorySize + uint32(len(d.Entries))*ImageResourceDirectoryEntrySize
+
+		for _, e := range item.entries {
+			if e.Directory != nil {
+				queue = append(queue, e.Directory)
+			}
+		}
+	}
+
+	for _, item := range dirs {
+		for _, e := range item.entries {
+			if e.Data != nil && e.Directory == nil {
+				dataOffset[e.Data] = size
+				datas = append(datas, e.Data)
+				size += ImageResourceDataEntrySize
+			}
+		}
+	}
+
+	for _, item := range dirs {
+		for _, e := range item.entries {
+			if _, ok := nameOffset[e.Name]; e.IsNamed() && !ok {
+				nameOffset[e.Name] = size
+				names = append(names, e.Name)
+				size += 2 + uint32(len(utf16.Encode([]rune(e.Name))))*2
+			}
+		}
+	}
+
+	dirSize = size
+	size = alignUp(size, 8)
+	dataRVA := make(map[*ResourceData]uint32)
+	for _, d := range datas {
+		dataRVA[d] = va + size
+		size = alignUp(size+uint32(len(d.data)), 8)
+	}
+
+	ret = make([]byte, size)
+	le := binary.LittleEndian
+	for _, item := range dirs {
+		d := item.dir
+		cur := item.offset
+		named := uint16(0)
+		for _, e := range item.entries {
+			if e.IsNamed() {
+				named++
+			}
+		}
+
+		le.PutUint32(ret[cur:], d.Characteristics)
+		le.PutUint32(ret[cur+4:], d.TimeDateStamp)
+		le.PutUint16(ret[cur+8:], d.MajorVersion)
+		le.PutUint16(ret[cur+10:], d.MinorVersion)
+		le.PutUint16(ret[cur+12:], named)
+		le.PutUint16(ret[cur+14:], uint16(len(item.entries))-named)
+		cur += ImageResourceDirectorySize
+
+		for _, e := range item.entries {
+			if e.IsNamed() {
+				le.PutUint32(ret[cur:], IMAGE_RESOURCE_NAME_IS_STRING|nameOffset[e.Name])
+			} else {
+				le.PutUint32(ret[cur:], e.ID)
+			}
+
+			if e.Directory != nil {
+				le.PutUint32(ret[cur+4:], IMAGE_RESOURCE_DATA_IS_DIRECTORY|dirOffset[e.Directory])
+			} else {
+				le.PutUint32(ret[cur+4:], dataOffset[e.Data])
+			}
+			cur += ImageResourceDirectoryEntrySize
+		}
+	}
+
+	for _, d := range datas {
+		cur := dataOffset[d]
+		d.RVA = dataRVA[d]
+		d.Size = uint32(len(d.data))
+		le.PutUint32(ret[cur:], d.RVA)
+		le.PutUint32(ret[cur+4:], d.Size)
+		le.PutUint32(ret[cur+8:], d.CodePage)
+		le.PutUint32(ret[cur+12:], d.Reserved)
+		copy(ret[d.RVA-va:], d.data)
+	}
+
+	for _, name := range names {
+		cur := nameOffset[name]
+		u := utf16.Encode([]rune(name))
+		le.PutUint16(ret[cur:], uint16(len(u)))
+		for i, v := range u {
+			le.PutUint16(ret[cur+2+uint32(i)*2:], v)
+		}
+	}
+
+	return
+}
+
+// 有名字的项在前, 按名字排序, 然后是按ID排序的项.
+func sortResourceEntries(entries []*ResourceEntry) []*ResourceEntry {
+	ret := append([]*ResourceEntry(nil), entries...)
+	sort.SliceStable(ret, func(i, j int) bool {
+		a, b := ret[i], ret[j]
+		if a.IsNamed() != b.IsNamed() {
+			return a.IsNamed()
+		}
+
+		if a.IsNamed() {
+			return compareUTF16(a.Name, b.Name) < 0
+		}
+		return a.ID < b.ID
+	})
+
+	return ret
+}
+
+func compareUTF16(a, b string) int {
+	x, y := utf16.Encode([]rune(a)), utf16.Encode([]rune(b))
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] != y[i] {
+			return int(x[i]) - int(y[i])
+		}
+	}
+
+	return len(x) - len(y)
+}
+
+func alignUp(size, align uint32) uint32 {
+	if size%align != 0 {
+		size = size - size%align + align
+	}
+
+	return size
+}
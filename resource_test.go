@@ -0,0 +1,238 @@
+package pefile
+
+import (
+	"bytes"
+	"debug/pe"
+	"encoding/binary"
+	"strings"
+	"testing"
+)
+
+func TestResources(t *testing.T) {
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	root, err := f.Resources()
+	if err != nil || root == nil {
+		t.Fatalf("Resources failed: %v", err)
+	}
+
+	list := root.List()
+	if len(list) != 1 || list[0].Type != ResID(RT_MANIFEST) || list[0].Name != ResID(1) || list[0].Lang != 0 {
+		t.Fatalf("resource list error: %+v", list)
+	}
+
+	e := root.Get(ResID(RT_MANIFEST), ResID(1), ResID(0))
+	if e == nil || e.Data != list[0].Data {
+		t.Fatalf("Get failed")
+	}
+
+	data, err := e.Data.Bytes()
+	if err != nil || len(data) != int(e.Data.Size) || !bytes.HasPrefix(data, []byte("<?xml")) || !strings.Contains(string(data), "asInvoker") {
+		t.Fatalf("resource data error: %v", err)
+	}
+
+	if v, err := Open("testdata/hello_vc_exe"); err != nil {
+		t.Fatalf("Open failed: %v", err)
+	} else if root, err = v.Resources(); root != nil || err != nil {
+		t.Fatalf("hello_vc_exe should not have resources")
+	}
+}
+
+func TestResourcesMalformed(t *testing.T) {
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	//根目录的子目录指向自己
+	cyclic := make([]byte, ImageResourceDirectorySize+ImageResourceDirectoryEntrySize)
+	binary.LittleEndian.PutUint16(cyclic[14:], 1)
+	binary.LittleEndian.PutUint32(cyclic[16:], 1)
+	binary.LittleEndian.PutUint32(cyclic[20:], IMAGE_RESOURCE_DATA_IS_DIRECTORY)
+
+	r := &resourceReader{p: f, data: cyclic, ancestors: make(map[uint32]bool)}
+	if _, err = r.readDirectory(0, 0); err == nil || !strings.Contains(err.Error(), "cyclic") {
+		t.Fatalf("cyclic directory should fail: %v", err)
+	}
+
+	binary.LittleEndian.PutUint32(cyclic[20:], 0x100)
+	r = &resourceReader{p: f, data: cyclic, ancestors: make(map[uint32]bool)}
+	if _, err = r.readDirectory(0, 0); err == nil || !strings.Contains(err.Error(), "out of bounds") {
+		t.Fatalf("out of bounds entry should fail: %v", err)
+	}
+
+	//两个类型共用一个子目录
+	shared := make([]byte, 72)
+	binary.LittleEndian.PutUint16(shared[14:], 2)
+	for i := 0; i < 2; i++ {
+		binary.LittleEndian.PutUint32(shared[16+i*8:], uint32(i+1))
+		binary.LittleEndian.PutUint32(shared[20+i*8:], 32|IMAGE_RESOURCE_DATA_IS_DIRECTORY)
+	}
+	binary.LittleEndian.PutUint16(shared[32+14:], 1)
+	binary.LittleEndian.PutUint32(shared[32+20:], 56)
+	binary.LittleEndian.PutUint32(shared[56:], 0x1000)
+	binary.LittleEndian.PutUint32(shared[60:], 4)
+
+	r = &resourceReader{p: f, data: shared, ancestors: make(map[uint32]bool)}
+	root, err := r.readDirectory(0, 0)
+	if err != nil || len(root.Entries) != 2 || root.Get(ResID(2), ResID(0)) == nil || root.Get(ResID(2), ResID(0)).Data.RVA != 0x1000 {
+		t.Fatalf("shared directory failed: %v", err)
+	}
+
+	//每层两项都指向下一层, 目录总数成倍增长
+	bomb := make([]byte, (maxResourceDepth+1)*32+16)
+	for i := 0; i <= maxResourceDepth; i++ {
+		dir := bomb[i*32:]
+		binary.LittleEndian.PutUint16(dir[14:], 2)
+		next := uint32(i+1) * 32
+		if i < maxResourceDepth {
+			next |= IMAGE_RESOURCE_DATA_IS_DIRECTORY
+		}
+		binary.LittleEndian.PutUint32(dir[16:], 1)
+		binary.LittleEndian.PutUint32(dir[20:], next)
+		binary.LittleEndian.PutUint32(dir[24:], 2)
+		binary.LittleEndian.PutUint32(dir[28:], next)
+	}
+	binary.LittleEndian.PutUint32(bomb[len(bomb)-16:], 0x1000)
+
+	r = &resourceReader{p: f, data: bomb, ancestors: make(map[uint32]bool)}
+	if _, err = r.readDirectory(0, 0); err == nil || !strings.Contains(err.Error(), "too many") {
+		t.Fatalf("too many directories should fail: %v", err)
+	}
+
+	//根目录的全部项指向同一个子目录, 子目录的全部项指向同一个数据项
+	const n = 0xffff
+	child := uint32(ImageResourceDirectorySize + n*ImageResourceDirectoryEntrySize)
+	leaf := child + ImageResourceDirectorySize + n*ImageResourceDirectoryEntrySize
+	wide := make([]byte, leaf+ImageResourceDataEntrySize)
+	for _, dir := range []struct{ offset, target uint32 }{{0, child | IMAGE_RESOURCE_DATA_IS_DIRECTORY}, {child, leaf}} {
+		binary.LittleEndian.PutUint16(wide[dir.offset+14:], n)
+		for i := uint32(0); i < n; i++ {
+			e := dir.offset + ImageResourceDirectorySize + i*ImageResourceDirectoryEntrySize
+			binary.LittleEndian.PutUint32(wide[e:], i)
+			binary.LittleEndian.PutUint32(wide[e+4:], dir.target)
+		}
+	}
+
+	r = &resourceReader{p: f, data: wide, ancestors: make(map[uint32]bool)}
+	if _, err = r.readDirectory(0, 0); err == nil || !strings.Contains(err.Error(), "too many entries") {
+		t.Fatalf("too many entries should fail: %v", err)
+	}
+}
+
+func TestSetResourcesSharedSection(t *testing.T) {
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	//资源目录所在的节中还有其它数据
+	dir := f.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_RESOURCE)
+	data, _ := f.ReadRVA(dir.VirtualAddress, dir.Size)
+	f.AddSection(".mixed", append(data, "keep me"...), IMAGE_SCN_CNT_INITIALIZED_DATA|IMAGE_SCN_MEM_READ)
+	va := f.mySections[0].virtualAddress
+	f.OptionHeaderView().SetDataDirectory(pe.IMAGE_DIRECTORY_ENTRY_RESOURCE, pe.DataDirectory{VirtualAddress: va, Size: dir.Size})
+
+	root, err := f.Resources()
+	if err != nil || root == nil {
+		t.Fatalf("Resources failed: %v", err)
+	}
+
+	root.Set(ResID(RT_RCDATA), ResID(1), 0, []byte("rcdata"))
+	if err = f.SetResources(root); err != nil {
+		t.Fatalf("SetResources failed: %v", err)
+	}
+
+	if d := f.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_RESOURCE); d.VirtualAddress == va || len(f.mySections) != 2 {
+		t.Fatalf("shared section should not be replaced: %+v", d)
+	}
+
+	if data, _ := f.ReadRVA(va+dir.Size, 7); string(data) != "keep me" {
+		t.Fatalf("section data lost: %q", data)
+	}
+
+	//没有子目录和数据的项
+	root.Entries = append(root.Entries, &ResourceEntry{ResourceID: ResID(RT_HTML)})
+	if err = f.SetResources(root); err == nil || !strings.Contains(err.Error(), "neither directory nor data") {
+		t.Fatalf("empty entry should fail: %v", err)
+	}
+}
+
+func TestSetResources(t *testing.T) {
+	for _, name := range []string{"hello_gcc_exe", "hello_vc_exe"} {
+		f, err := Open("testdata/" + name)
+		if err != nil {
+			t.Fatalf("Open failed: %v", err)
+		}
+		defer f.Close()
+
+		root, err := f.Resources()
+		if err != nil {
+			t.Fatalf("Resources failed: %v", err)
+		} else if root == nil {
+			root = &ResourceDirectory{}
+		}
+
+		large := bytes.Repeat([]byte("pefile"), 0x400)
+		root.Set(ResID(RT_RCDATA), ResName("PAYLOAD"), 0x409, []byte("rcdata payload"))
+		root.Set(ResID(RT_RCDATA), ResName("LARGE"), 0x409, large)
+		root.Set(ResID(RT_RCDATA), ResID(7), 0x804, []byte("numeric"))
+		root.Set(ResName("CUSTOM"), ResID(1), 0, []byte("custom"))
+		root.Set(ResID(RT_RCDATA), ResID(8), 0, []byte("deleted"))
+		if !root.Delete(ResID(RT_RCDATA), ResID(8), 0) || root.Get(ResID(RT_RCDATA), ResID(8)) != nil {
+			t.Fatalf("Delete failed")
+		}
+
+		count := len(root.List())
+		if err = f.SetResources(root); err != nil {
+			t.Fatalf("SetResources failed: %v", err)
+		}
+
+		var buf bytes.Buffer
+		if err = f.WriteTo(&buf); err != nil {
+			t.Fatalf("WriteTo failed: %v", err)
+		}
+
+		n, err := New(bytes.NewReader(buf.Bytes()))
+		if err != nil {
+			t.Fatalf("reopen failed: %v", err)
+		}
+
+		if root, err = n.Resources(); err != nil || root == nil || len(root.List()) != count {
+			t.Fatalf("%v resources error: %v", name, err)
+		}
+
+		if root.Entries[0].Name != "CUSTOM" || root.Entries[1].ID != RT_RCDATA {
+			t.Fatalf("resource entries not sorted")
+		}
+
+		for path, should := range map[[3]ResourceID][]byte{
+			{ResID(RT_RCDATA), ResName("PAYLOAD"), ResID(0x409)}: []byte("rcdata payload"),
+			{ResID(RT_RCDATA), ResName("LARGE"), ResID(0x409)}:   large,
+			{ResID(RT_RCDATA), ResID(7), ResID(0x804)}:           []byte("numeric"),
+		} {
+			e := root.Get(path[:]...)
+			if e == nil || e.Data == nil || e.Data.RVA%8 != 0 {
+				t.Fatalf("%v resource %v not found", name, path)
+			}
+
+			if data, err := e.Data.Bytes(); err != nil || bytes.Compare(data, should) != 0 {
+				t.Fatalf("%v resource %v data error: %v", name, path, err)
+			}
+		}
+
+		if name == "hello_gcc_exe" {
+			if e := root.Get(ResID(RT_MANIFEST), ResID(1), ResID(0)); e == nil {
+				t.Fatalf("manifest lost")
+			} else if data, _ := e.Data.Bytes(); !bytes.HasPrefix(data, []byte("<?xml")) {
+				t.Fatalf("manifest data error")
+			}
+		}
+	}
+}
@@ -0,0 +1,151 @@
+package pefile
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+const (
+	richSignature = 0x68636952 //"Rich"
+	danSSignature = 0x536e6144 //"DanS"
+)
+
+var ErrInvalidRichHeader = errors.New("pefile: invalid rich header")
+
+type RichEntry struct {
+	ProductID uint16
+	Build     uint16
+	Count     uint32
+}
+
+func (e RichEntry) CompID() uint32 {
+	return uint32(e.ProductID)<<16 | uint32(e.Build)
+}
+
+type RichHeader struct {
+	Key      uint32 //文件中记录的异或key, 也就是校验和
+	Checksum uint32 //根据dos头重新计算的校验和
+	Offset   uint32 //"DanS"在文件中的偏移
+	Entries  []RichEntry
+}
+
+func (r *RichHeader) Valid() bool {
+	return r.Key == r.Checksum
+}
+
+// 没有rich头时返回nil, nil.
+func (p *PeFile) RichHeader() (*RichHeader, error) {
+	return parseRichHeader(p.dosHeader)
+}
+
+func (p *PeFile) StripRichHeader() bool {
+	r, err := parseRichHeader(p.dosHeader)
+	if r == nil || err != nil {
+		return false
+	}
+
+	p.SetDosStub(p.dosHeader[dosHeaderSize:r.Offset])
+	return true
+}
+
+// 替换(或添加)rich头, 写入时重新计算校验和. 原有的rich头会被删掉.
+func (p *PeFile) SetRichHeader(entries []RichEntry) {
+	if len(p.dosHeader) < dosHeaderSize+4 {
+		p.ResetDosHeader()
+	}
+
+	stub := p.dosHeader[dosHeaderSize : len(p.dosHeader)-4]
+	if r, err := parseRichHeader(p.dosHeader); r != nil && err == nil {
+		stub = p.dosHeader[dosHeaderSize:r.Offset]
+	}
+
+	offset := dosHeaderSize + len(stub)
+	if offset%16 != 0 { //"DanS"按16字节对齐
+		offset = offset - offset%16 + 16
+	}
+
+	data := make([]byte, offset, offset+16+len(entries)*8+8)
+	copy(data, p.dosHeader[:dosHeaderSize])
+	copy(data[dosHeaderSize:], stub)
+	key := richChecksum(data, uint32(offset), entries)
+
+	var dword [4]byte
+	put := func(v uint32) {
+		binary.LittleEndian.PutUint32(dword[:], v)
+		data = append(data, dword[:]...)
+	}
+
+	put(danSSignature ^ key)
+	put(key)
+	put(key)
+	put(key)
+	for _, e := range entries {
+		put(e.CompID() ^ key)
+		put(e.Count ^ key)
+	}
+	put(richSignature)
+	put(key)
+
+	p.SetDosStub(data[dosHeaderSize:])
+}
+
+func parseRichHeader(header []byte) (*RichHeader, error) {
+	end := len(header) - 4
+	rich := -1
+	for i := dosHeaderSize; i+8 <= end; i += 4 {
+		if binary.LittleEndian.Uint32(header[i:]) == richSignature {
+			rich = i
+			break
+		}
+	}
+
+	if rich < 0 {
+		return nil, nil
+	}
+
+	key := binary.LittleEndian.Uint32(header[rich+4:])
+	start := -1
+	for i := rich - 4; i >= dosHeaderSize; i -= 4 {
+		if binary.LittleEndian.Uint32(header[i:])^key == danSSignature {
+			start = i
+			break
+		}
+	}
+
+	if start < 0 || rich-start < 16 || (rich-start)%8 != 0 {
+		return nil, ErrInvalidRichHeader
+	}
+
+	for i := start + 4; i < start+16; i += 4 { //"DanS"后面是3个为0的dword
+		if binary.LittleEndian.Uint32(header[i:]) != key {
+			return nil, ErrInvalidRichHeader
+		}
+	}
+
+	ret := &RichHeader{Key: key, Offset: uint32(start)}
+	for i := start + 16; i < rich; i += 8 {
+		id := binary.LittleEndian.Uint32(header[i:]) ^ key
+		count := binary.LittleEndian.Uint32(header[i+4:]) ^ key
+		ret.Entries = append(ret.Entries, RichEntry{uint16(id >> 16), uint16(id), count})
+	}
+
+	ret.Checksum = richChecksum(header, uint32(start), ret.Entries)
+	return ret, nil
+}
+
+func richChecksum(header []byte, offset uint32, entries []RichEntry) uint32 {
+	sum := offset
+	for i := uint32(0); i < offset; i++ {
+		if i >= dosLfanewIndex && i < dosLfanewIndex+4 { //跳过e_lfanew
+			continue
+		}
+		sum += bits.RotateLeft32(uint32(header[i]), int(i))
+	}
+
+	for _, e := range entries {
+		sum += bits.RotateLeft32(e.CompID(), int(e.Count))
+	}
+
+	return sum
+}
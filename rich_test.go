@@ -0,0 +1,63 @@
+package pefile
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestRichHeader(t *testing.T) {
+	for _, header := range [][]byte{peHeaderB8, peHeader100} {
+		r, err := parseRichHeader(header)
+		if err != nil || r == nil {
+			t.Fatalf("parse rich header failed: %v", err)
+		}
+
+		if !r.Valid() || r.Offset != 0x80 {
+			t.Fatalf("rich header checksum should: %x, get: %x", r.Key, r.Checksum)
+		}
+	}
+
+	if r, err := parseRichHeader(peHeader80); r != nil || err != nil {
+		t.Fatalf("rich header should not exist")
+	}
+
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	r, err := f.RichHeader()
+	if err != nil || r == nil || !r.Valid() || len(r.Entries) == 0 {
+		t.Fatalf("RichHeader failed: %v", err)
+	}
+
+	entries := append(r.Entries, RichEntry{0x0104, 30729, 7})
+	f.SetRichHeader(entries)
+	n, err := f.RichHeader()
+	if err != nil || n == nil || !n.Valid() || len(n.Entries) != len(entries) || n.Entries[len(entries)-1] != entries[len(entries)-1] {
+		t.Fatalf("SetRichHeader failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	o, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if r, err = o.RichHeader(); err != nil || r == nil || !r.Valid() {
+		t.Fatalf("rich header lost after WriteTo: %v", err)
+	}
+
+	if !o.StripRichHeader() {
+		t.Fatalf("StripRichHeader failed")
+	}
+
+	if r, err = o.RichHeader(); r != nil || err != nil || len(o.DosHeader()) != 0x80+4 {
+		t.Fatalf("rich header should be stripped")
+	}
+}
@@ -2,36 +2,52 @@ package pefile
 
 import (
 	"debug/pe"
+	"errors"
 	"sort"
 	"strconv"
 )
 
-func buildSectionRaw(f *pe.File, headerSize uint32, align uint32) *sectionRaw {
+// 映像中超过8字节的节名保存在符号表的字符串表中, 没有符号表时不能使用.
+var ErrSectionName = errors.New("pefile: section name longer than 8 bytes requires a symbol table")
+
+func (p *PeFile) buildSectionRaw(headerSize uint32) (*sectionRaw, error) {
+	f := p.File
+	align := p.fileAlignment
 	sections := f.Sections
-	header := make([]pe.SectionHeader32, len(sections))
+	mySections := p.mySections
+	header := make([]pe.SectionHeader32, len(sections)+len(mySections))
 	stringTable := f.StringTable
 	addString := false
-	var data []sectionRawData
+	var data, myData []sectionRawData
 
-	for i, v := range sections {
-		size := len(v.Name)
-		s := &header[i]
-
-		if size <= 8 {
-			copy(s.Name[:], []byte(v.Name))
+	setName := func(s *pe.SectionHeader32, name string) error {
+		if len(name) <= 8 {
+			copy(s.Name[:], []byte(name))
+		} else if f.OptionalHeader != nil && f.NumberOfSymbols == 0 {
+			return ErrSectionName
 		} else {
 			s.Name[0] = '/'
-			index := searchString(v.Name, stringTable)
+			index := searchString(name, stringTable)
 			if index == 0 { //需要添加
 				addString = true
 				index = uint32(len(stringTable) + 4)
-				stringTable = append(stringTable, []byte(v.Name)...)
+				stringTable = append(stringTable, []byte(name)...)
+				stringTable = append(stringTable, 0)
 			}
 
 			indexStr := strconv.Itoa(int(index))
 			copy(s.Name[1:], []byte(indexStr))
 		}
 
+		return nil
+	}
+
+	for i, v := range sections {
+		s := &header[i]
+		if err := setName(s, v.Name); err != nil {
+			return nil, err
+		}
+
 		s.VirtualSize = v.VirtualSize
 		s.VirtualAddress = v.VirtualAddress
 		s.SizeOfRawData = v.Size
@@ -43,17 +59,39 @@ func buildSectionRaw(f *pe.File, headerSize uint32, align uint32) *sectionRaw {
 		s.Characteristics = v.Characteristics
 
 		if v.Size > 0 {
-			dataCur := sectionRawData{uint32(i), nil, v.Offset, v.Size}
+			dataCur := sectionRawData{uint32(i), nil, v.Offset, v.Size, v}
 			data = append(data, dataCur)
 		}
 
 		if v.NumberOfRelocations != 0 {
 			dataSize := uint32(v.NumberOfRelocations) * uint32(10)
-			dataCur := sectionRawData{uint32(i), v.Relocs, v.PointerToRelocations, dataSize}
+			dataCur := sectionRawData{uint32(i), v.Relocs, v.PointerToRelocations, dataSize, v}
 			data = append(data, dataCur)
 		}
 	}
 
+	for i, v := range mySections {
+		index := len(sections) + i
+		s := &header[index]
+		if err := setName(s, v.name); err != nil {
+			return nil, err
+		}
+
+		s.VirtualSize = v.virtualSize
+		s.VirtualAddress = v.virtualAddress
+		s.Characteristics = v.characteristics
+
+		if len(v.data) > 0 && (v.characteristics&IMAGE_SCN_CNT_UNINITIALIZED_DATA) == 0 {
+			s.SizeOfRawData = p.alignSize(uint32(len(v.data)), true)
+			myData = append(myData, sectionRawData{uint32(index), v.data, 0, s.SizeOfRawData, nil})
+		}
+
+		if len(v.relocs) > 0 {
+			s.NumberOfRelocations = uint16(len(v.relocs))
+			myData = append(myData, sectionRawData{uint32(index), v.relocs, 0, uint32(len(v.relocs)) * 10, nil})
+		}
+	}
+
 	if addString {
 		f.StringTable = stringTable
 	}
@@ -88,14 +126,145 @@ func buildSectionRaw(f *pe.File, headerSize uint32, align uint32) *sectionRaw {
 		from += s.size
 	}
 
-	return &sectionRaw{header, data, from}
+	for i, _ := range myData { //新加的节放在原有数据之后
+		s := &myData[i]
+		if _, ok := s.data.([]pe.Reloc); ok {
+			header[s.index].PointerToRelocations = from
+		} else {
+			from = p.alignSize(from, true)
+			header[s.index].PointerToRawData = from
+		}
+		s.pos = from
+		from += s.size
+	}
+	data = append(data, myData...)
+
+	if f.OptionalHeader != nil { //映像的节表需要按虚拟地址排序
+		header, data = sortSectionHeader(header, data)
+	}
+
+	return &sectionRaw{header, data, from}, nil
+}
+
+func sortSectionHeader(header []pe.SectionHeader32, data []sectionRawData) ([]pe.SectionHeader32, []sectionRawData) {
+	order := make([]int, len(header))
+	for i := range order {
+		order[i] = i
+	}
+
+	sort.SliceStable(order, func(i, j int) bool {
+		return header[order[i]].VirtualAddress < header[order[j]].VirtualAddress
+	})
+
+	index := make([]uint32, len(header))
+	sorted := make([]pe.SectionHeader32, len(header))
+	for i, v := range order {
+		sorted[i] = header[v]
+		index[v] = uint32(i)
+	}
+
+	for i := range data {
+		data[i].index = index[data[i].index]
+	}
+
+	return sorted, data
+}
+
+// 把build生成的数据放到虚拟地址为va的节中, 节的空间不够(或va为0)时新加一个name节.
+// 原来的节是最后一个节时删掉, 否则保留以免虚拟地址不连续.
+// build根据节的虚拟地址生成数据, 数据大小不能依赖于虚拟地址.
+func (p *PeFile) placeSection(va uint32, name string, characteristics uint32, build func(va uint32) []byte) uint32 {
+	data := build(va)
+	if va != 0 {
+		if p.replaceSectionData(va, data) {
+			return va
+		}
+
+		if va == p.lastSectionAddress() {
+			p.removeSection(func(_ string, cur uint32) bool { return cur == va })
+		}
+	}
+
+	va, size := p.addSectionAllocAddress(len(data))
+	p.mySections = append(p.mySections, customSection{name, build(va), characteristics, size, va, nil})
+	p.File.NumberOfSections++
+	p.sectionChanged()
+
+	return va
+}
+
+func (p *PeFile) lastSectionAddress() uint32 {
+	last := uint32(0)
+	for _, s := range p.File.Sections {
+		if s.VirtualAddress > last {
+			last = s.VirtualAddress
+		}
+	}
+
+	for _, s := range p.mySections {
+		if s.virtualAddress > last {
+			last = s.virtualAddress
+		}
+	}
+
+	return last
+}
+
+// 替换从va开始的节的数据, 和后面的节重叠时返回false.
+func (p *PeFile) replaceSectionData(va uint32, data []byte) bool {
+	end := uint64(va) + uint64(p.alignSize(uint32(len(data)), false))
+	for _, s := range p.File.Sections {
+		if s.VirtualAddress > va && uint64(s.VirtualAddress) < end {
+			return false
+		}
+	}
+
+	for _, s := range p.mySections {
+		if s.virtualAddress > va && uint64(s.virtualAddress) < end {
+			return false
+		}
+	}
+
+	size := uint32(len(data))
+	resize := func(old uint32) {
+		p.va.Remove(uint64(va), uint64(p.alignSize(old, false)))
+		p.va.Insert(uint64(va), uint64(p.alignSize(size, false)))
+	}
+
+	for _, s := range p.File.Sections {
+		if s.VirtualAddress == va {
+			resize(virtualSize(s))
+			if p.sectionData == nil {
+				p.sectionData = make(map[*pe.Section][]byte)
+			}
+
+			p.sectionData[s] = data
+			s.VirtualSize = size
+			s.Size = p.alignSize(size, true)
+			p.sectionChanged()
+			return true
+		}
+	}
+
+	for i := range p.mySections {
+		if s := &p.mySections[i]; s.virtualAddress == va {
+			resize(s.virtualSize)
+			s.data = data
+			s.virtualSize = size
+			p.sectionChanged()
+			return true
+		}
+	}
+
+	return false
 }
 
 type sectionRawData struct {
-	index uint32
-	data  interface{} //为nil表示为rawdata, []pe.Reloc表示为重定位信息.
-	pos   uint32
-	size  uint32
+	index   uint32
+	data    interface{} //为nil表示为rawdata, []pe.Reloc表示为重定位信息, []byte表示新加节的数据.
+	pos     uint32
+	size    uint32
+	section *pe.Section
 }
 
 type sectionRaw struct {
@@ -110,4 +279,5 @@ type customSection struct {
 	characteristics uint32
 	virtualSize     uint32
 	virtualAddress  uint32
+	relocs          []pe.Reloc //只用于COFF对象
 }
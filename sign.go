@@ -0,0 +1,347 @@
+package pefile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"time"
+	"unicode/utf16"
+)
+
+var ErrKeyMismatch = errors.New("pefile: signer does not match the certificate")
+
+var (
+	oidRSAEncryption            = asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 1, 1}
+	oidSpcIndividualCodeSigning = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 311, 2, 1, 21}
+)
+
+var ecdsaOIDs = map[crypto.Hash]asn1.ObjectIdentifier{
+	crypto.SHA1:   {1, 2, 840, 10045, 4, 1},
+	crypto.SHA256: {1, 2, 840, 10045, 4, 3, 2},
+	crypto.SHA384: {1, 2, 840, 10045, 4, 3, 3},
+	crypto.SHA512: {1, 2, 840, 10045, 4, 3, 4},
+}
+
+type SignOptions struct {
+	Hash        crypto.Hash //默认为SHA256
+	Description string      //SpcSpOpusInfo中的程序名称
+	URL         string      //SpcSpOpusInfo中的链接
+	SigningTime time.Time   //不为零时添加signingTime属性, signtool不添加
+}
+
+// 对映像签名并替换已有的签名. certs[0]是签名证书, 之后是中间证书.
+// 同时更新CheckSum, 之后用WriteTo写出即可.
+func (p *PeFile) Sign(signer crypto.Signer, certs []*x509.Certificate, opts *SignOptions) error {
+	sig, err := p.sign(signer, certs, opts)
+	if err != nil {
+		return err
+	}
+
+	return p.setSignature(sig)
+}
+
+// 添加嵌套签名, 通常用于在SHA1签名之外再添加SHA256签名. 没有签名时和Sign相同.
+func (p *PeFile) AddNestedSignature(signer crypto.Signer, certs []*x509.Certificate, opts *SignOptions) error {
+	primary := p.signature()
+	if primary == nil {
+		return p.Sign(signer, certs, opts)
+	}
+
+	nested, err := p.sign(signer, certs, opts)
+	if err != nil {
+		return err
+	}
+
+	sig, err := AttachNestedSignature(primary.Data, nested)
+	if err != nil {
+		return err
+	}
+
+	return p.setSignature(sig)
+}
+
+// 给主签名添加RFC3161时间戳, token是TSA返回的TimeStampToken(ContentInfo),
+// 时间戳的摘要是Signatures()[0].Signer.Signature的哈希.
+func (p *PeFile) AddTimestamp(token []byte) error {
+	primary := p.signature()
+	if primary == nil {
+		return ErrNotSigned
+	}
+
+	sig, err := AttachTimestamp(primary.Data, token)
+	if err != nil {
+		return err
+	}
+
+	return p.setSignature(sig)
+}
+
+func (p *PeFile) sign(signer crypto.Signer, certs []*x509.Certificate, opts *SignOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &SignOptions{}
+	}
+
+	hash := opts.Hash
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+
+	digest, err := p.AuthenticodeHash(hash)
+	if err != nil {
+		return nil, err
+	}
+
+	o := *opts
+	o.Hash = hash
+	return SignAuthenticode(digest, signer, certs, &o)
+}
+
+// 返回第一个PKCS#7签名, 没有时返回nil.
+func (p *PeFile) signature() *Certificate {
+	for _, c := range p.certificates {
+		if c.Type == WIN_CERT_TYPE_PKCS_SIGNED_DATA {
+			return c
+		}
+	}
+
+	return nil
+}
+
+// 替换证书表并重新计算CheckSum. 证书表不参与Authenticode哈希.
+func (p *PeFile) setSignature(sig []byte) error {
+	p.SetCertificates([]*Certificate{{WIN_CERT_REVISION_2_0, WIN_CERT_TYPE_PKCS_SIGNED_DATA, sig}})
+	return p.WriteToWithOptions(ioutil.Discard, WriteOptions{UpdateChecksum: true})
+}
+
+// 用映像的Authenticode哈希digest生成签名(ContentInfo), 哈希算法由opts.Hash指定.
+func SignAuthenticode(digest []byte, signer crypto.Signer, certs []*x509.Certificate, opts *SignOptions) ([]byte, error) {
+	if opts == nil {
+		opts = &SignOptions{}
+	}
+
+	hash := opts.Hash
+	if hash == 0 {
+		hash = crypto.SHA256
+	}
+
+	if oidFromHash(hash) == nil || !hash.Available() || len(digest) != hash.Size() {
+		return nil, fmt.Errorf("pefile: unsupported hash %v", hash)
+	}
+
+	if len(certs) == 0 {
+		return nil, ErrKeyMismatch
+	}
+
+	pub, err := x509.MarshalPKIXPublicKey(signer.Public())
+	if err != nil || !bytes.Equal(pub, certs[0].RawSubjectPublicKeyInfo) {
+		return nil, ErrKeyMismatch
+	}
+
+	var encryption pkix.AlgorithmIdentifier
+	switch signer.Public().(type) {
+	case *rsa.PublicKey:
+		encryption = pkix.AlgorithmIdentifier{Algorithm: oidRSAEncryption, Parameters: asn1.NullRawValue}
+	case *ecdsa.PublicKey:
+		encryption = pkix.AlgorithmIdentifier{Algorithm: ecdsaOIDs[hash]}
+	default:
+		return nil, fmt.Errorf("pefile: unsupported key type %T", signer.Public())
+	}
+
+	alg := pkix.AlgorithmIdentifier{Algorithm: oidFromHash(hash), Parameters: asn1.NullRawValue}
+	content, err := asn1.Marshal(spcIndirectDataContent{
+		Data:          spcAttributeTypeAndOptionalValue{Type: oidSpcPeImageData, Value: asn1.RawValue{FullBytes: spcPeImageData()}},
+		MessageDigest: digestInfo{alg, digest},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	//签名的是去掉标记和长度的内容
+	var inner asn1.RawValue
+	asn1.Unmarshal(content, &inner)
+	h := hash.New()
+	h.Write(inner.Bytes)
+
+	attrs := [][]byte{
+		marshalAttribute(oidContentType, oidSpcIndirectDataContent),
+		marshalAttribute(oidMessageDigest, h.Sum(nil)),
+		marshalAttribute(oidSpcStatementType, []asn1.ObjectIdentifier{oidSpcIndividualCodeSigning}),
+		marshalAttribute(oidSpcSpOpusInfo, asn1.RawValue{FullBytes: spcSpOpusInfo(opts.Description, opts.URL)}),
+	}
+	if !opts.SigningTime.IsZero() {
+		attrs = append(attrs, marshalAttribute(oidSigningTime, opts.SigningTime.UTC()))
+	}
+
+	//DER的SET OF按编码排序
+	sort.Slice(attrs, func(i, j int) bool { return bytes.Compare(attrs[i], attrs[j]) < 0 })
+	set, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: bytes.Join(attrs, nil)})
+	h = hash.New()
+	h.Write(set)
+	signature, err := signer.Sign(rand.Reader, h.Sum(nil), hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var raw []byte
+	for _, c := range certs {
+		raw = append(raw, c.Raw...)
+	}
+
+	sd := signedData{
+		Version:          1,
+		DigestAlgorithms: []pkix.AlgorithmIdentifier{alg},
+		ContentInfo:      contentInfo{oidSpcIndirectDataContent, explicitTag(0, content)},
+		Certificates:     explicitTag(0, raw),
+		SignerInfos: []signerInfo{{
+			Version:                   1,
+			IssuerAndSerialNumber:     issuerAndSerial{asn1.RawValue{FullBytes: certs[0].RawIssuer}, certs[0].SerialNumber},
+			DigestAlgorithm:           alg,
+			AuthenticatedAttributes:   explicitTag(0, bytes.Join(attrs, nil)),
+			DigestEncryptionAlgorithm: encryption,
+			EncryptedDigest:           signature,
+		}},
+	}
+
+	return marshalSignedData(sd)
+}
+
+// 把nested作为嵌套签名添加到sig的未认证属性中.
+func AttachNestedSignature(sig, nested []byte) ([]byte, error) {
+	if _, err := ParseAuthenticode(nested); err != nil {
+		return nil, err
+	}
+
+	return addUnauthenticated(sig, oidNestedSignature, nested)
+}
+
+// 把RFC3161时间戳添加到sig的未认证属性中, 检查时间戳的摘要和签名是否对应.
+func AttachTimestamp(sig, token []byte) ([]byte, error) {
+	s, err := ParseAuthenticode(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	t, err := parseRFC3161(token)
+	if err != nil {
+		return nil, err
+	}
+
+	if t.HashAlgorithm == 0 || !t.HashAlgorithm.Available() {
+		return nil, fmt.Errorf("%v: unsupported timestamp hash", ErrInvalidSignature)
+	}
+
+	h := t.HashAlgorithm.New()
+	h.Write(s.Signer.Signature)
+	if !bytes.Equal(h.Sum(nil), t.HashedMessage) {
+		return nil, fmt.Errorf("%v: timestamp digest mismatch", ErrInvalidSignature)
+	}
+
+	return addUnauthenticated(sig, oidRFC3161CounterSign, token)
+}
+
+// 未认证属性不参与签名, 可以直接修改. 已有同类型的属性时添加到它的值中.
+func addUnauthenticated(sig []byte, oid asn1.ObjectIdentifier, value []byte) ([]byte, error) {
+	sd, _, err := parseSignedData(sig)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(sd.SignerInfos) != 1 {
+		return nil, ErrInvalidSignature
+	}
+
+	si := &sd.SignerInfos[0]
+	attrs, err := parseAttributes(si.UnauthenticatedAttributes.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var buf bytes.Buffer
+	found := false
+	for _, a := range attrs {
+		if a.Type.Equal(oid) && !found {
+			a.Values.Bytes = append(append([]byte{}, a.Values.Bytes...), value...)
+			a.Values.FullBytes = nil
+			found = true
+		}
+
+		der, err := asn1.Marshal(a)
+		if err != nil {
+			return nil, err
+		}
+		buf.Write(der)
+	}
+
+	if !found {
+		buf.Write(marshalAttribute(oid, asn1.RawValue{FullBytes: value}))
+	}
+
+	si.UnauthenticatedAttributes = explicitTag(1, buf.Bytes())
+	return marshalSignedData(*sd)
+}
+
+func marshalSignedData(sd signedData) ([]byte, error) {
+	der, err := asn1.Marshal(sd)
+	if err != nil {
+		return nil, err
+	}
+
+	return asn1.Marshal(contentInfo{oidSignedData, explicitTag(0, der)})
+}
+
+// 只有一个值的属性.
+func marshalAttribute(oid asn1.ObjectIdentifier, value interface{}) []byte {
+	der, _ := asn1.Marshal(value)
+	ret, _ := asn1.Marshal(attribute{oid, asn1.RawValue{Tag: asn1.TagSet, IsCompound: true, Bytes: der}})
+	return ret
+}
+
+// SpcPeImageData: 空的flags和"<<<Obsolete>>>"的链接, 和signtool相同.
+func spcPeImageData() []byte {
+	flags, _ := asn1.Marshal(asn1.BitString{})
+	link, _ := asn1.Marshal(explicitTag(2, spcString("<<<Obsolete>>>"))) //SpcLink的file选项
+	file := explicitTag(0, link)
+	der, _ := asn1.Marshal(struct {
+		Flags asn1.RawValue
+		File  asn1.RawValue
+	}{asn1.RawValue{FullBytes: flags}, file})
+	return der
+}
+
+// SpcSpOpusInfo: programName [0] SpcString和moreInfo [1] SpcLink都是可选的.
+func spcSpOpusInfo(name, url string) []byte {
+	var buf bytes.Buffer
+	if name != "" {
+		der, _ := asn1.Marshal(explicitTag(0, spcString(name)))
+		buf.Write(der)
+	}
+
+	if url != "" {
+		link, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: []byte(url)}) //[0] IMPLICIT IA5String
+		der, _ := asn1.Marshal(explicitTag(1, link))
+		buf.Write(der)
+	}
+
+	der, _ := asn1.Marshal(asn1.RawValue{Tag: asn1.TagSequence, IsCompound: true, Bytes: buf.Bytes()})
+	return der
+}
+
+// SpcString的unicode选项: [0] IMPLICIT BMPString.
+func spcString(s string) []byte {
+	var bmp []byte
+	for _, c := range utf16.Encode([]rune(s)) {
+		bmp = append(bmp, byte(c>>8), byte(c))
+	}
+
+	der, _ := asn1.Marshal(asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, Bytes: bmp})
+	return der
+}
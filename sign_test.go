@@ -0,0 +1,135 @@
+package pefile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"debug/pe"
+	"encoding/asn1"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func TestSign(t *testing.T) {
+	cert, key := testCertificate(t, "pefile signer")
+	caCert, caKey := testCertificate(t, "pefile ca")
+	tsaCert, tsaKey := testCertificate(t, "pefile tsa")
+
+	//RSA证书由caCert签发, 签名时带上中间证书
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("GenerateKey failed: %v", err)
+	}
+	der, err := x509.CreateCertificate(rand.Reader, &x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: "pefile rsa"},
+		NotBefore:    testSigningTime.Add(-time.Hour),
+		NotAfter:     testSigningTime.Add(time.Hour * 24 * 365 * 100),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageCodeSigning},
+	}, caCert, &rsaKey.PublicKey, caKey)
+	if err != nil {
+		t.Fatalf("CreateCertificate failed: %v", err)
+	}
+	rsaCert, _ := x509.ParseCertificate(der)
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	roots.AddCert(caCert)
+	roots.AddCert(tsaCert)
+
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if err = f.Sign(key, []*x509.Certificate{rsaCert}, nil); err != ErrKeyMismatch {
+		t.Fatalf("expect ErrKeyMismatch, got %v", err)
+	}
+
+	if err = f.AddTimestamp(nil); err != ErrNotSigned {
+		t.Fatalf("expect ErrNotSigned, got %v", err)
+	}
+
+	opts := &SignOptions{Hash: crypto.SHA1, Description: "hello", URL: "https://example.com", SigningTime: testSigningTime}
+	if err = f.Sign(key, []*x509.Certificate{cert}, opts); err != nil {
+		t.Fatalf("Sign failed: %v", err)
+	}
+
+	if err = f.AddNestedSignature(rsaKey, []*x509.Certificate{rsaCert, caCert}, nil); err != nil {
+		t.Fatalf("AddNestedSignature failed: %v", err)
+	}
+
+	sigs, err := f.Signatures()
+	if err != nil || len(sigs) != 1 {
+		t.Fatalf("Signatures failed: %v", err)
+	}
+
+	imprint := sha256.Sum256(sigs[0].Signer.Signature)
+	info := testMarshal(t, tstInfo{1, asn1.ObjectIdentifier{1, 2, 3}, messageImprint{
+		pkix.AlgorithmIdentifier{Algorithm: oidFromHash(crypto.SHA256)}, imprint[:]}, big.NewInt(1), testSigningTime})
+	token := testSignedData(t, oidTSTInfo, info, tsaCert, tsaKey)
+	if err = f.AddTimestamp(token); err != nil {
+		t.Fatalf("AddTimestamp failed: %v", err)
+	}
+
+	//摘要不对应的时间戳
+	info = testMarshal(t, tstInfo{1, asn1.ObjectIdentifier{1, 2, 3}, messageImprint{
+		pkix.AlgorithmIdentifier{Algorithm: oidFromHash(crypto.SHA256)}, make([]byte, sha256.Size)}, big.NewInt(1), testSigningTime})
+	if err = f.AddTimestamp(testSignedData(t, oidTSTInfo, info, tsaCert, tsaKey)); err == nil {
+		t.Fatalf("timestamp should not match the signature")
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	data := buf.Bytes()
+	g, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if ok, err := g.VerifyChecksum(); !ok || err != nil {
+		t.Fatalf("checksum error: %v", err)
+	}
+
+	dir := g.OptionHeaderView().DataDirectory(pe.IMAGE_DIRECTORY_ENTRY_SECURITY)
+	if dir.VirtualAddress%8 != 0 || int(dir.VirtualAddress+dir.Size) != len(data) || len(g.Certificates()) != 1 {
+		t.Fatalf("security directory error: %+v", dir)
+	}
+
+	if err = g.VerifySignature(roots); err != nil {
+		t.Fatalf("VerifySignature failed: %v", err)
+	}
+
+	if sigs, err = g.Signatures(); err != nil || len(sigs) != 1 {
+		t.Fatalf("Signatures failed: %v", err)
+	}
+
+	s := sigs[0]
+	if s.DigestAlgorithm != crypto.SHA1 || !s.Signer.SigningTime.Equal(testSigningTime) || len(s.Timestamps) != 1 ||
+		len(s.Nested) != 1 || s.Nested[0].DigestAlgorithm != crypto.SHA256 || len(s.Nested[0].Certificates) != 2 {
+		t.Fatalf("signature error: %+v", s)
+	}
+
+	//再次添加嵌套签名放在同一个属性中
+	if err = g.AddNestedSignature(key, []*x509.Certificate{cert}, &SignOptions{Hash: crypto.SHA512}); err != nil {
+		t.Fatalf("AddNestedSignature failed: %v", err)
+	}
+
+	if sigs, err = g.Signatures(); err != nil || len(sigs[0].Nested) != 2 || len(sigs[0].Signer.unauthenticated) != 2 {
+		t.Fatalf("nested signatures error: %v", err)
+	}
+
+	if err = g.VerifySignature(roots); err != nil {
+		t.Fatalf("VerifySignature failed: %v", err)
+	}
+}
@@ -0,0 +1,116 @@
+package pefile
+
+import (
+	"encoding/binary"
+	"errors"
+	"unicode/utf16"
+)
+
+var ErrInvalidStringTable = errors.New("pefile: invalid string table")
+
+// RT_STRING的每个资源包含16个字符串, 名字为n的资源包含ID (n-1)*16到(n-1)*16+15.
+const stringBundleSize = 16
+
+func ParseStringBundle(data []byte) (ret [stringBundleSize]string, err error) {
+	pos := 0
+	for i := range ret {
+		if pos+2 > len(data) {
+			if pos == len(data) { //有的编译器省略后面的空字符串
+				break
+			}
+			return ret, ErrInvalidStringTable
+		}
+
+		size := int(binary.LittleEndian.Uint16(data[pos:])) * 2
+		pos += 2
+		if pos+size > len(data) {
+			return ret, ErrInvalidStringTable
+		}
+
+		ret[i] = decodeUTF16(data[pos : pos+size])
+		pos += size
+	}
+
+	return
+}
+
+func StringBundleBytes(strings [stringBundleSize]string) []byte {
+	var ret []byte
+	for _, s := range strings {
+		u := utf16.Encode([]rune(s))
+		ret = append(ret, byte(len(u)), byte(len(u)>>8))
+		for _, v := range u {
+			ret = append(ret, byte(v), byte(v>>8))
+		}
+	}
+
+	return ret
+}
+
+// 返回一个语言的全部字符串, 没有时返回空的map.
+func (p *PeFile) StringTable(lang uint16) (map[uint16]string, error) {
+	ret := make(map[uint16]string)
+	root, err := p.Resources()
+	if root == nil || err != nil {
+		return ret, err
+	}
+
+	for _, r := range root.List() {
+		if r.Type != ResID(RT_STRING) || r.Name.IsNamed() || r.Lang != lang || r.Name.ID == 0 {
+			continue
+		}
+
+		data, err := r.Data.Bytes()
+		if err != nil {
+			return nil, err
+		}
+
+		bundle, err := ParseStringBundle(data)
+		if err != nil {
+			return nil, err
+		}
+
+		for i, s := range bundle {
+			if s != "" {
+				ret[uint16((r.Name.ID-1)*stringBundleSize+uint32(i))] = s
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// 替换一个语言的全部字符串并重新生成资源节, 空字符串表示删除.
+func (p *PeFile) SetStringTable(lang uint16, strings map[uint16]string) error {
+	root, err := p.Resources()
+	if err != nil {
+		return err
+	} else if root == nil {
+		root = &ResourceDirectory{}
+	}
+
+	for _, r := range root.List() {
+		if r.Type == ResID(RT_STRING) && r.Lang == lang {
+			root.Delete(r.Type, r.Name, r.Lang)
+		}
+	}
+
+	bundles := make(map[uint32]*[stringBundleSize]string)
+	for id, s := range strings {
+		if s == "" {
+			continue
+		}
+
+		name := uint32(id)/stringBundleSize + 1
+		if bundles[name] == nil {
+			bundles[name] = &[stringBundleSize]string{}
+		}
+		bundles[name][id%stringBundleSize] = s
+	}
+
+	for name, bundle := range bundles {
+		root.Set(ResID(RT_STRING), ResID(name), lang, StringBundleBytes(*bundle))
+	}
+
+	return p.SetResources(root)
+}
@@ -0,0 +1,68 @@
+package pefile
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStringTable(t *testing.T) {
+	var bundle [stringBundleSize]string
+	bundle[1], bundle[15] = "Hello", "世界"
+	data := StringBundleBytes(bundle)
+	if len(data) != stringBundleSize*2+5*2+2*2 {
+		t.Fatalf("bundle size error: %v", len(data))
+	}
+
+	if b, err := ParseStringBundle(data); err != nil || b != bundle {
+		t.Fatalf("ParseStringBundle failed: %v", err)
+	}
+
+	if _, err := ParseStringBundle(data[:len(data)-1]); err == nil {
+		t.Fatalf("ParseStringBundle should fail with truncated data")
+	}
+
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	strings := map[uint16]string{1: "one", 15: "fifteen", 16: "sixteen", 0xffff: "last"}
+	if err = f.SetStringTable(0x409, strings); err != nil {
+		t.Fatalf("SetStringTable failed: %v", err)
+	}
+
+	if err = f.SetStringTable(0x804, map[uint16]string{1: "一"}); err != nil {
+		t.Fatalf("SetStringTable failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	g, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	read, err := g.StringTable(0x409)
+	if err != nil || len(read) != len(strings) {
+		t.Fatalf("StringTable failed: %v %v", read, err)
+	}
+
+	for id, s := range strings {
+		if read[id] != s {
+			t.Fatalf("string %v error: %q", id, read[id])
+		}
+	}
+
+	root, _ := g.Resources()
+	if len(root.Find(ResID(RT_STRING), ResID(1))) != 2 || len(root.Find(ResID(RT_STRING), ResID(4096))) != 1 {
+		t.Fatalf("bundle names error")
+	}
+
+	if read, err = g.StringTable(0x804); err != nil || len(read) != 1 || read[1] != "一" {
+		t.Fatalf("StringTable failed: %v %v", read, err)
+	}
+}
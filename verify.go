@@ -0,0 +1,207 @@
+package pefile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrNotSigned      = errors.New("pefile: image is not signed")
+	ErrDigestMismatch = errors.New("pefile: authenticode digest mismatch")
+)
+
+const securityDirectoryIndex = 4
+
+// 按Authenticode的规则计算WriteTo输出的映像的哈希. 没有证书表时按填充到8字节计算,
+// 和添加证书表之后的结果相同.
+func (p *PeFile) AuthenticodeHash(hash crypto.Hash) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := p.WriteTo(&buf); err != nil {
+		return nil, err
+	}
+
+	return AuthenticodeHash(buf.Bytes(), hash)
+}
+
+// 计算data(完整的文件)的哈希, 跳过CheckSum, SECURITY目录项和证书表.
+// 证书表之后的数据也参与计算, 签名之后附加的数据会使验证失败.
+func AuthenticodeHash(data []byte, hash crypto.Hash) ([]byte, error) {
+	if hash == 0 || !hash.Available() {
+		return nil, fmt.Errorf("pefile: hash %v is not available", hash)
+	}
+
+	checksum := checksumOffset(data)
+	if checksum < 0 {
+		return nil, ErrNoOptionHeader
+	}
+
+	option := checksum - 64
+	dirs := option + 96
+	if binary.LittleEndian.Uint16(data[option:]) == 0x20b {
+		dirs = option + 112
+	}
+
+	end, trailer, security := len(data), len(data), -1
+	if dirs <= len(data) && binary.LittleEndian.Uint32(data[dirs-4:]) > securityDirectoryIndex &&
+		dirs+(securityDirectoryIndex+1)*8 <= len(data) {
+		security = dirs + securityDirectoryIndex*8
+		offset, size := binary.LittleEndian.Uint32(data[security:]), binary.LittleEndian.Uint32(data[security+4:])
+		if size > 0 && uint64(offset)+uint64(size) <= uint64(len(data)) && int(offset) > security {
+			end, trailer = int(offset), int(offset)+int(size)
+		}
+	}
+
+	h := hash.New()
+	h.Write(data[:checksum])
+	if security < 0 {
+		h.Write(data[checksum+4 : end])
+	} else {
+		h.Write(data[checksum+4 : security])
+		h.Write(data[security+8 : end])
+	}
+	h.Write(make([]byte, int(alignUp(uint32(end), 8))-end))
+	h.Write(data[trailer:])
+
+	return h.Sum(nil), nil
+}
+
+// 检查全部签名(包括嵌套的签名): 映像的哈希, 签名, 证书链和时间戳. 有时间戳时按时间戳的时间检查证书链.
+func (p *PeFile) VerifySignature(roots *x509.CertPool) error {
+	sigs, err := p.Signatures()
+	if err != nil {
+		return err
+	} else if len(sigs) == 0 {
+		return ErrNotSigned
+	}
+
+	for len(sigs) > 0 {
+		s := sigs[0]
+		sigs = append(sigs[1:], s.Nested...)
+
+		digest, err := p.AuthenticodeHash(s.DigestAlgorithm)
+		if err != nil {
+			return err
+		}
+
+		if !bytes.Equal(digest, s.Digest) {
+			return ErrDigestMismatch
+		}
+
+		if err = s.Verify(roots); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// 检查签名和证书链, 不检查映像的哈希.
+func (s *AuthenticodeSignature) Verify(roots *x509.CertPool) error {
+	var content asn1.RawValue
+	if _, err := asn1.Unmarshal(s.content, &content); err != nil {
+		return ErrInvalidSignature
+	}
+
+	if err := s.Signer.verify(content.Bytes); err != nil {
+		return err
+	}
+
+	now := time.Now()
+	for _, t := range s.Timestamps {
+		if err := t.verify(s.Signer, roots); err != nil {
+			return err
+		}
+		now = t.Time
+	}
+
+	return verifyChain(s.Signer.Certificate, s.Certificates, roots, now, x509.ExtKeyUsageCodeSigning)
+}
+
+func (t *Timestamp) verify(signer *SignerInfo, roots *x509.CertPool) error {
+	if t.HashAlgorithm == 0 || !t.HashAlgorithm.Available() {
+		return fmt.Errorf("%v: unsupported timestamp hash", ErrInvalidSignature)
+	}
+
+	h := t.HashAlgorithm.New()
+	h.Write(signer.Signature)
+	if !bytes.Equal(h.Sum(nil), t.HashedMessage) {
+		return fmt.Errorf("%v: timestamp digest mismatch", ErrInvalidSignature)
+	}
+
+	content := signer.Signature
+	if t.RFC3161 {
+		content = t.content
+	}
+
+	if err := t.Signer.verify(content); err != nil {
+		return err
+	}
+
+	return verifyChain(t.Signer.Certificate, t.Certificates, roots, t.Time, x509.ExtKeyUsageTimeStamping)
+}
+
+func verifyChain(cert *x509.Certificate, certs []*x509.Certificate, roots *x509.CertPool, now time.Time, usage x509.ExtKeyUsage) error {
+	intermediates := x509.NewCertPool()
+	for _, c := range certs {
+		intermediates.AddCert(c)
+	}
+
+	_, err := cert.Verify(x509.VerifyOptions{Roots: roots, Intermediates: intermediates, CurrentTime: now,
+		KeyUsages: []x509.ExtKeyUsage{usage}})
+	return err
+}
+
+// content是被签名的数据, 有认证属性时签名的是认证属性, 其中的MessageDigest是content的摘要.
+func (s *SignerInfo) verify(content []byte) error {
+	if s.Certificate == nil {
+		return fmt.Errorf("%v: signer certificate not found", ErrInvalidSignature)
+	}
+
+	if s.DigestAlgorithm == 0 || !s.DigestAlgorithm.Available() {
+		return fmt.Errorf("%v: unsupported digest algorithm", ErrInvalidSignature)
+	}
+
+	signed := content
+	if s.attributes != nil {
+		h := s.DigestAlgorithm.New()
+		h.Write(content)
+		if !bytes.Equal(h.Sum(nil), s.MessageDigest) {
+			return fmt.Errorf("%v: message digest mismatch", ErrInvalidSignature)
+		}
+		signed = s.attributes
+	}
+
+	algo := signatureAlgorithm(s.Certificate.PublicKey, s.DigestAlgorithm)
+	if err := s.Certificate.CheckSignature(algo, signed, s.Signature); err != nil {
+		return fmt.Errorf("%v: %v", ErrInvalidSignature, err)
+	}
+
+	return nil
+}
+
+// SignerInfo中的签名算法经常只写rsaEncryption, 所以按公钥的类型和摘要算法确定.
+func signatureAlgorithm(pub interface{}, hash crypto.Hash) x509.SignatureAlgorithm {
+	algos := map[crypto.Hash][2]x509.SignatureAlgorithm{
+		crypto.SHA1:   {x509.SHA1WithRSA, x509.ECDSAWithSHA1},
+		crypto.SHA256: {x509.SHA256WithRSA, x509.ECDSAWithSHA256},
+		crypto.SHA384: {x509.SHA384WithRSA, x509.ECDSAWithSHA384},
+		crypto.SHA512: {x509.SHA512WithRSA, x509.ECDSAWithSHA512},
+	}
+
+	switch pub.(type) {
+	case *rsa.PublicKey:
+		return algos[hash][0]
+	case *ecdsa.PublicKey:
+		return algos[hash][1]
+	}
+
+	return x509.UnknownSignatureAlgorithm
+}
@@ -0,0 +1,220 @@
+package pefile
+
+import (
+	"bytes"
+	"crypto"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/pem"
+	"io/ioutil"
+	"math/big"
+	"testing"
+)
+
+// 在签名中添加未认证属性, 未认证属性不影响签名.
+func testAddUnauthenticated(t *testing.T, der []byte, attrs ...[]byte) []byte {
+	var ci contentInfo
+	var sd signedData
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	} else if _, err = asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		t.Fatalf("Unmarshal failed: %v", err)
+	}
+
+	si := &sd.SignerInfos[0]
+	old := si.UnauthenticatedAttributes.Bytes
+	si.UnauthenticatedAttributes = explicitTag(1, bytes.Join(append([][]byte{old}, attrs...), nil))
+	return testMarshal(t, contentInfo{oidSignedData, explicitTag(0, testMarshal(t, sd))})
+}
+
+func TestAuthenticodeHash(t *testing.T) {
+	f, err := Open("testdata/hello_vc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	f.SetOverlay([]byte("overlay"))
+	digest, err := f.AuthenticodeHash(crypto.SHA256)
+	if err != nil {
+		t.Fatalf("AuthenticodeHash failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	f.WriteTo(&buf)
+	data := buf.Bytes()
+	offset := checksumOffset(data)
+
+	//CheckSum和SECURITY目录不参与计算
+	data[offset] ^= 0xff
+	data[offset+64] ^= 0xff
+	if h, _ := AuthenticodeHash(data, crypto.SHA256); !bytes.Equal(h, digest) {
+		t.Fatalf("hash should skip checksum and security directory")
+	}
+
+	data[len(data)-1] ^= 0xff
+	if h, _ := AuthenticodeHash(data, crypto.SHA256); bytes.Equal(h, digest) {
+		t.Fatalf("hash should cover the overlay")
+	}
+
+	//添加证书表之后不变
+	f.SetCertificates([]*Certificate{{WIN_CERT_REVISION_2_0, WIN_CERT_TYPE_PKCS_SIGNED_DATA, []byte("signature")}})
+	if h, err := f.AuthenticodeHash(crypto.SHA256); err != nil || !bytes.Equal(h, digest) {
+		t.Fatalf("certificate table changed the hash: %v", err)
+	}
+
+	if _, err = AuthenticodeHash([]byte("MZ"), crypto.SHA256); err != ErrNoOptionHeader {
+		t.Fatalf("expect ErrNoOptionHeader, got %v", err)
+	}
+}
+
+func TestVerifySignature(t *testing.T) {
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if err = f.VerifySignature(nil); err != ErrNotSigned {
+		t.Fatalf("expect ErrNotSigned, got %v", err)
+	}
+
+	digest, err := f.AuthenticodeHash(crypto.SHA256)
+	if err != nil {
+		t.Fatalf("AuthenticodeHash failed: %v", err)
+	}
+
+	nested, nestedCert := testAuthenticode(t, digest)
+	signature, cert := testAuthenticode(t, digest, testAttribute(t, oidNestedSignature, nested))
+
+	//时间戳签名的是签名者的签名
+	sigs, err := ParseAuthenticode(signature)
+	if err != nil {
+		t.Fatalf("ParseAuthenticode failed: %v", err)
+	}
+	imprint := sha256.Sum256(sigs.Signer.Signature)
+	tsaCert, tsaKey := testCertificate(t, "pefile tsa")
+	info := testMarshal(t, tstInfo{1, asn1.ObjectIdentifier{1, 2, 3}, messageImprint{
+		pkix.AlgorithmIdentifier{Algorithm: oidFromHash(crypto.SHA256)}, imprint[:]}, big.NewInt(1), testSigningTime})
+	signature = testAddUnauthenticated(t, signature, testAttribute(t, oidRFC3161CounterSign, testSignedData(t, oidTSTInfo, info, tsaCert, tsaKey)))
+
+	roots := x509.NewCertPool()
+	roots.AddCert(cert)
+	roots.AddCert(nestedCert)
+	roots.AddCert(tsaCert)
+
+	f.SetCertificates([]*Certificate{{WIN_CERT_REVISION_2_0, WIN_CERT_TYPE_PKCS_SIGNED_DATA, signature}})
+	var buf bytes.Buffer
+	if err = f.WriteToWithOptions(&buf, WriteOptions{UpdateChecksum: true}); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	data := buf.Bytes()
+	g, err := New(bytes.NewReader(data))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	if err = g.VerifySignature(roots); err != nil {
+		t.Fatalf("VerifySignature failed: %v", err)
+	}
+
+	//不信任嵌套签名的证书
+	partial := x509.NewCertPool()
+	partial.AddCert(cert)
+	partial.AddCert(tsaCert)
+	if err = g.VerifySignature(partial); err == nil {
+		t.Fatalf("nested signature should not be trusted")
+	}
+
+	//修改节数据
+	text := g.File.Section(".text")
+	data[text.Offset+16] ^= 0xff
+	if g, _ = New(bytes.NewReader(data)); g.VerifySignature(roots) != ErrDigestMismatch {
+		t.Fatalf("expect ErrDigestMismatch")
+	}
+}
+
+// signed_dotnet_dll是.NET SDK中用signtool签名的引用程序集(Microsoft.AspNetCore.Mvc.Formatters.Json.dll),
+// microsoft_roots.pem是SDK的trustedroots中的Microsoft Root Certificate Authority 2011和2010.
+func TestVerifyExternalSignature(t *testing.T) {
+	f, err := Open("testdata/signed_dotnet_dll")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	sigs, err := f.Signatures()
+	if err != nil || len(sigs) != 1 {
+		t.Fatalf("Signatures failed: %v", err)
+	}
+
+	s := sigs[0]
+	if s.DigestAlgorithm != crypto.SHA256 || len(s.Certificates) != 2 || len(s.Timestamps) != 1 || !s.Timestamps[0].RFC3161 {
+		t.Fatalf("signature error: %+v", s)
+	}
+
+	digest, err := f.AuthenticodeHash(s.DigestAlgorithm)
+	if err != nil || !bytes.Equal(digest, s.Digest) {
+		t.Fatalf("hash %x does not match SpcIndirectDataContent %x: %v", digest, s.Digest, err)
+	}
+
+	file, err := ioutil.ReadFile("testdata/signed_dotnet_dll")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	raw, err := ioutil.ReadFile("testdata/microsoft_roots.pem")
+	if err != nil {
+		t.Fatalf("ReadFile failed: %v", err)
+	}
+
+	roots := x509.NewCertPool()
+	if !roots.AppendCertsFromPEM(raw) {
+		t.Fatalf("AppendCertsFromPEM failed")
+	}
+
+	if err = f.VerifySignature(roots); err != nil {
+		t.Fatalf("VerifySignature failed: %v", err)
+	}
+
+	//只信任签名的根证书时时间戳验证失败
+	block, _ := pem.Decode(raw)
+	root, err := x509.ParseCertificate(block.Bytes)
+	if err != nil || root.Subject.CommonName != "Microsoft Root Certificate Authority 2011" {
+		t.Fatalf("ParseCertificate failed: %v", err)
+	}
+
+	codeSigning := x509.NewCertPool()
+	codeSigning.AddCert(root)
+	if err = f.VerifySignature(codeSigning); err == nil {
+		t.Fatalf("timestamp root should be required")
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	data := buf.Bytes()
+	if !bytes.Equal(data, file) {
+		t.Fatalf("rewrite changed the file")
+	}
+
+	//证书表之后附加的数据
+	trailed := append(append([]byte{}, file...), "trailer"...)
+	if h, err := AuthenticodeHash(trailed, crypto.SHA256); err != nil || bytes.Equal(h, s.Digest) {
+		t.Fatalf("data after the certificate table should be hashed: %v", err)
+	}
+
+	if g, _ := New(bytes.NewReader(trailed)); g.VerifySignature(roots) != ErrDigestMismatch {
+		t.Fatalf("data after the certificate table should fail the verification")
+	}
+
+	data[f.File.Section(".text").Offset+16] ^= 0xff
+	if g, _ := New(bytes.NewReader(data)); g.VerifySignature(roots) != ErrDigestMismatch {
+		t.Fatalf("expect ErrDigestMismatch")
+	}
+}
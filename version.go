@@ -0,0 +1,387 @@
+package pefile
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf16"
+)
+
+var ErrInvalidVersionInfo = errors.New("pefile: invalid version info")
+
+type VersionTranslation struct {
+	Lang     uint16
+	CodePage uint16
+}
+
+// 例如"040904b0".
+func (t VersionTranslation) Key() string {
+	return fmt.Sprintf("%04x%04x", t.Lang, t.CodePage)
+}
+
+type VersionString struct {
+	Key, Value string
+}
+
+type VersionStringTable struct {
+	Key     string //语言和代码页, 例如"040904b0"
+	Strings []VersionString
+}
+
+func (t *VersionStringTable) Get(key string) (string, bool) {
+	for _, s := range t.Strings {
+		if s.Key == key {
+			return s.Value, true
+		}
+	}
+
+	return "", false
+}
+
+func (t *VersionStringTable) Set(key, value string) {
+	for i := range t.Strings {
+		if t.Strings[i].Key == key {
+			t.Strings[i].Value = value
+			return
+		}
+	}
+
+	t.Strings = append(t.Strings, VersionString{key, value})
+}
+
+func (t *VersionStringTable) Delete(key string) {
+	for i := range t.Strings {
+		if t.Strings[i].Key == key {
+			t.Strings = append(t.Strings[:i], t.Strings[i+1:]...)
+			return
+		}
+	}
+}
+
+type VersionInfo struct {
+	Fixed        *VSFixedFileInfo
+	StringTables []*VersionStringTable
+	Translations []VersionTranslation
+}
+
+// 新建版本信息, 包含一个指定语言的字符串表.
+func NewVersionInfo(lang, codePage uint16) *VersionInfo {
+	t := VersionTranslation{lang, codePage}
+	return &VersionInfo{
+		Fixed: &VSFixedFileInfo{Signature: VS_FFI_SIGNATURE, StrucVersion: VS_FFI_STRUCVERSION,
+			FileFlagsMask: VS_FFI_FILEFLAGSMASK, FileOS: VOS_NT_WINDOWS32, FileType: VFT_APP},
+		StringTables: []*VersionStringTable{{Key: t.Key()}},
+		Translations: []VersionTranslation{t},
+	}
+}
+
+// 返回第一个包含key的字符串表中的值.
+func (v *VersionInfo) Get(key string) (string, bool) {
+	for _, t := range v.StringTables {
+		if value, ok := t.Get(key); ok {
+			return value, true
+		}
+	}
+
+	return "", false
+}
+
+// 修改所有字符串表.
+func (v *VersionInfo) SetString(key, value string) {
+	for _, t := range v.StringTables {
+		t.Set(key, value)
+	}
+}
+
+func (v *VersionInfo) DeleteString(key string) {
+	for _, t := range v.StringTables {
+		t.Delete(key)
+	}
+}
+
+// 同时修改VS_FIXEDFILEINFO和FileVersion字符串.
+func (v *VersionInfo) SetFileVersion(major, minor, build, revision uint16) {
+	v.fixed().FileVersionMS = uint32(major)<<16 | uint32(minor)
+	v.fixed().FileVersionLS = uint32(build)<<16 | uint32(revision)
+	v.SetString("FileVersion", versionString(major, minor, build, revision))
+}
+
+// 同时修改VS_FIXEDFILEINFO和ProductVersion字符串.
+func (v *VersionInfo) SetProductVersion(major, minor, build, revision uint16) {
+	v.fixed().ProductVersionMS = uint32(major)<<16 | uint32(minor)
+	v.fixed().ProductVersionLS = uint32(build)<<16 | uint32(revision)
+	v.SetString("ProductVersion", versionString(major, minor, build, revision))
+}
+
+func (v *VersionInfo) FileVersion() (major, minor, build, revision uint16) {
+	f := v.fixed()
+	return uint16(f.FileVersionMS >> 16), uint16(f.FileVersionMS), uint16(f.FileVersionLS >> 16), uint16(f.FileVersionLS)
+}
+
+func (v *VersionInfo) ProductVersion() (major, minor, build, revision uint16) {
+	f := v.fixed()
+	return uint16(f.ProductVersionMS >> 16), uint16(f.ProductVersionMS), uint16(f.ProductVersionLS >> 16), uint16(f.ProductVersionLS)
+}
+
+func (v *VersionInfo) fixed() *VSFixedFileInfo {
+	if v.Fixed == nil {
+		v.Fixed = &VSFixedFileInfo{Signature: VS_FFI_SIGNATURE, StrucVersion: VS_FFI_STRUCVERSION, FileFlagsMask: VS_FFI_FILEFLAGSMASK}
+	}
+
+	return v.Fixed
+}
+
+func versionString(major, minor, build, revision uint16) string {
+	var s []string
+	for _, v := range []uint16{major, minor, build, revision} {
+		s = append(s, strconv.Itoa(int(v)))
+	}
+
+	return strings.Join(s, ".")
+}
+
+// 版本信息中的一个块: wLength, wValueLength, wType, szKey, Value, Children.
+type versionBlock struct {
+	key      string
+	text     bool
+	value    []byte
+	children []*versionBlock
+}
+
+func ParseVersionInfo(data []byte) (*VersionInfo, error) {
+	root, _, err := parseVersionBlock(data, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	if root.key != VS_VERSION_INFO_KEY {
+		return nil, ErrInvalidVersionInfo
+	}
+
+	ret := &VersionInfo{}
+	if len(root.value) >= VSFixedFileInfoSize {
+		ret.Fixed = &VSFixedFileInfo{}
+		binary.Read(bytes.NewReader(root.value), binary.LittleEndian, ret.Fixed)
+		if ret.Fixed.Signature != VS_FFI_SIGNATURE {
+			return nil, ErrInvalidVersionInfo
+		}
+	}
+
+	for _, c := range root.children {
+		switch c.key {
+		case VS_STRING_FILE_INFO_KEY:
+			for _, t := range c.children {
+				table := &VersionStringTable{Key: t.key}
+				for _, s := range t.children {
+					table.Strings = append(table.Strings, VersionString{s.key, decodeVersionString(s)})
+				}
+				ret.StringTables = append(ret.StringTables, table)
+			}
+		case VS_VAR_FILE_INFO_KEY:
+			for _, v := range c.children {
+				if v.key != VS_TRANSLATION_KEY {
+					continue
+				}
+
+				for i := 0; i+4 <= len(v.value); i += 4 {
+					ret.Translations = append(ret.Translations,
+						VersionTranslation{binary.LittleEndian.Uint16(v.value[i:]), binary.LittleEndian.Uint16(v.value[i+2:])})
+				}
+			}
+		}
+	}
+
+	return ret, nil
+}
+
+// 字符串的值以0结尾.
+func decodeVersionString(b *versionBlock) string {
+	s := decodeUTF16(b.value)
+	if i := strings.IndexByte(s, 0); i >= 0 {
+		s = s[:i]
+	}
+
+	return s
+}
+
+// 返回块和块结束的位置(没有对齐).
+func parseVersionBlock(data []byte, pos int) (*versionBlock, int, error) {
+	if pos+6 > len(data) {
+		return nil, 0, ErrInvalidVersionInfo
+	}
+
+	length := int(binary.LittleEndian.Uint16(data[pos:]))
+	valueLength := int(binary.LittleEndian.Uint16(data[pos+2:]))
+	end := pos + length
+	if length < 6 || end > len(data) {
+		return nil, 0, ErrInvalidVersionInfo
+	}
+
+	ret := &versionBlock{text: binary.LittleEndian.Uint16(data[pos+4:]) == 1}
+	cur := pos + 6
+	for ; cur+2 <= end; cur += 2 {
+		if binary.LittleEndian.Uint16(data[cur:]) == 0 {
+			break
+		}
+	}
+
+	if cur+2 > end {
+		return nil, 0, ErrInvalidVersionInfo
+	}
+
+	ret.key = decodeUTF16(data[pos+6 : cur])
+	cur = pos + int(alignUp(uint32(cur+2-pos), 4))
+
+	if ret.text {
+		valueLength *= 2 //文本的长度是WORD数
+	}
+
+	if cur+valueLength > end {
+		if ret.text { //有的资源编译器把字节数当成WORD数
+			valueLength = end - cur
+		} else {
+			return nil, 0, ErrInvalidVersionInfo
+		}
+	}
+
+	if valueLength > 0 {
+		ret.value = data[cur : cur+valueLength]
+	}
+	cur = pos + int(alignUp(uint32(cur+valueLength-pos), 4))
+
+	for cur < end {
+		child, childEnd, err := parseVersionBlock(data, cur)
+		if err != nil {
+			return nil, 0, err
+		}
+
+		ret.children = append(ret.children, child)
+		cur = pos + int(alignUp(uint32(childEnd-pos), 4))
+	}
+
+	return ret, end, nil
+}
+
+func (v *VersionInfo) Bytes() []byte {
+	root := &versionBlock{key: VS_VERSION_INFO_KEY}
+	if v.Fixed != nil {
+		var buf bytes.Buffer
+		binary.Write(&buf, binary.LittleEndian, v.Fixed)
+		root.value = buf.Bytes()
+	}
+
+	if len(v.StringTables) > 0 {
+		info := &versionBlock{key: VS_STRING_FILE_INFO_KEY, text: true}
+		for _, t := range v.StringTables {
+			table := &versionBlock{key: t.Key, text: true}
+			for _, s := range t.Strings {
+				table.children = append(table.children, &versionBlock{key: s.Key, text: true, value: encodeUTF16(s.Value + "\x00")})
+			}
+			info.children = append(info.children, table)
+		}
+		root.children = append(root.children, info)
+	}
+
+	if len(v.Translations) > 0 {
+		value := make([]byte, len(v.Translations)*4)
+		for i, t := range v.Translations {
+			binary.LittleEndian.PutUint16(value[i*4:], t.Lang)
+			binary.LittleEndian.PutUint16(value[i*4+2:], t.CodePage)
+		}
+
+		vars := &versionBlock{key: VS_VAR_FILE_INFO_KEY, text: true}
+		vars.children = append(vars.children, &versionBlock{key: VS_TRANSLATION_KEY, value: value})
+		root.children = append(root.children, vars)
+	}
+
+	return root.bytes()
+}
+
+func (b *versionBlock) bytes() []byte {
+	ret := make([]byte, 6, 64)
+	ret = append(ret, encodeUTF16(b.key+"\x00")...)
+	ret = padVersionBlock(ret)
+	ret = append(ret, b.value...)
+
+	valueLength := len(b.value)
+	if b.text {
+		valueLength /= 2
+		binary.LittleEndian.PutUint16(ret[4:], 1)
+	}
+	binary.LittleEndian.PutUint16(ret[2:], uint16(valueLength))
+
+	for _, c := range b.children {
+		ret = padVersionBlock(ret)
+		ret = append(ret, c.bytes()...)
+	}
+
+	binary.LittleEndian.PutUint16(ret, uint16(len(ret)))
+	return ret
+}
+
+func padVersionBlock(data []byte) []byte {
+	for len(data)%4 != 0 {
+		data = append(data, 0)
+	}
+
+	return data
+}
+
+func encodeUTF16(s string) []byte {
+	u := utf16.Encode([]rune(s))
+	ret := make([]byte, len(u)*2)
+	for i, v := range u {
+		binary.LittleEndian.PutUint16(ret[i*2:], v)
+	}
+
+	return ret
+}
+
+// 返回第一个RT_VERSION资源, 没有时返回nil, nil.
+func (p *PeFile) VersionInfo() (*VersionInfo, error) {
+	root, err := p.Resources()
+	if root == nil || err != nil {
+		return nil, err
+	}
+
+	for _, r := range root.List() {
+		if r.Type == ResID(RT_VERSION) {
+			data, err := r.Data.Bytes()
+			if err != nil {
+				return nil, err
+			}
+
+			return ParseVersionInfo(data)
+		}
+	}
+
+	return nil, nil
+}
+
+// 替换RT_VERSION资源并重新生成资源节. 没有版本资源时按第一个翻译的语言添加.
+func (p *PeFile) SetVersionInfo(v *VersionInfo) error {
+	root, err := p.Resources()
+	if err != nil {
+		return err
+	} else if root == nil {
+		root = &ResourceDirectory{}
+	}
+
+	name, lang := ResID(1), uint16(0)
+	if len(v.Translations) > 0 {
+		lang = v.Translations[0].Lang
+	}
+
+	for _, r := range root.List() {
+		if r.Type == ResID(RT_VERSION) {
+			name, lang = r.Name, r.Lang
+			break
+		}
+	}
+
+	root.Set(ResID(RT_VERSION), name, lang, v.Bytes())
+	return p.SetResources(root)
+}
@@ -0,0 +1,97 @@
+package pefile
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestVersionInfo(t *testing.T) {
+	v := NewVersionInfo(0x409, 1200)
+	v.SetFileVersion(1, 2, 3, 4)
+	v.SetProductVersion(1, 2, 0, 0)
+	v.SetString("CompanyName", "pefile")
+	v.SetString("FileDescription", "版本信息测试")
+	v.SetString("Comments", "")
+
+	data := v.Bytes()
+	if len(data)%4 != 0 {
+		t.Fatalf("version info size %v not aligned", len(data))
+	}
+
+	n, err := ParseVersionInfo(data)
+	if err != nil {
+		t.Fatalf("ParseVersionInfo failed: %v", err)
+	}
+
+	if bytes.Compare(n.Bytes(), data) != 0 {
+		t.Fatalf("version info round trip failed")
+	}
+
+	if s, ok := n.Get("FileDescription"); !ok || s != "版本信息测试" {
+		t.Fatalf("FileDescription error: %v", s)
+	}
+
+	if s, ok := n.Get("Comments"); !ok || s != "" {
+		t.Fatalf("empty string error")
+	}
+
+	if a, b, c, d := n.FileVersion(); a != 1 || b != 2 || c != 3 || d != 4 {
+		t.Fatalf("FileVersion error")
+	}
+
+	if len(n.Translations) != 1 || n.Translations[0] != (VersionTranslation{0x409, 1200}) || n.StringTables[0].Key != "040904b0" {
+		t.Fatalf("translation error: %+v", n.Translations)
+	}
+
+	f, err := Open("testdata/hello_gcc_exe")
+	if err != nil {
+		t.Fatalf("Open failed: %v", err)
+	}
+	defer f.Close()
+
+	if v, err := f.VersionInfo(); v != nil || err != nil {
+		t.Fatalf("hello_gcc_exe should not have version info")
+	}
+
+	if err = f.SetVersionInfo(n); err != nil {
+		t.Fatalf("SetVersionInfo failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err = f.WriteTo(&buf); err != nil {
+		t.Fatalf("WriteTo failed: %v", err)
+	}
+
+	g, err := New(bytes.NewReader(buf.Bytes()))
+	if err != nil {
+		t.Fatalf("reopen failed: %v", err)
+	}
+
+	v, err = g.VersionInfo()
+	if err != nil || v == nil {
+		t.Fatalf("VersionInfo failed: %v", err)
+	}
+
+	v.SetFileVersion(2, 0, 0, 1)
+	v.SetString("ProductName", "pefile test")
+	if err = g.SetVersionInfo(v); err != nil {
+		t.Fatalf("SetVersionInfo failed: %v", err)
+	}
+
+	if v, err = g.VersionInfo(); err != nil || v == nil {
+		t.Fatalf("VersionInfo failed: %v", err)
+	}
+
+	if s, _ := v.Get("FileVersion"); s != "2.0.0.1" || v.Fixed.FileVersionMS != 0x20000 || v.Fixed.FileVersionLS != 1 {
+		t.Fatalf("FileVersion not changed: %v", s)
+	}
+
+	if s, _ := v.Get("ProductName"); s != "pefile test" {
+		t.Fatalf("ProductName error: %v", s)
+	}
+
+	root, _ := g.Resources()
+	if len(root.Find(ResID(RT_VERSION), ResID(1))) != 1 || root.Get(ResID(RT_MANIFEST), ResID(1), ResID(0)) == nil {
+		t.Fatalf("resources error")
+	}
+}